@@ -0,0 +1,342 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/store"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminMiddleware admits either the basic auth credentials or the bearer token
+// of a user with the admin role. Requests without credentials get the basic auth
+// challenge, unless basic auth isn't configured and only bearer tokens are taken.
+func (app *application) AdminMiddleware() echo.MiddlewareFunc {
+	basic := app.BasicAuthMiddleware()
+	bearer := app.AuthTokenMiddleware()
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withBasic := basic(next)
+		withBearer := bearer(app.requireAdminRole(next))
+
+		if !app.config.auth.basic.enabled() {
+			return withBearer
+		}
+
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get("Authorization")
+			if header == "" || strings.HasPrefix(header, "Basic ") {
+				return withBasic(c)
+			}
+			return withBearer(c)
+		}
+	}
+}
+
+// requireAdminRole must run after AuthTokenMiddleware. The role is checked on the
+// current user as well as in the token, so demoted admins lose access right away.
+func (app *application) requireAdminRole(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := getUserFromContext(c)
+		granted, _ := c.Get("scopes").([]string)
+
+		if user == nil || user.Role != store.RoleAdmin || !auth.HasScopes(granted, auth.ScopeAdmin) {
+			return app.forbiddenResponse(c)
+		}
+
+		return next(c)
+	}
+}
+
+// BasicAuthMiddleware admits requests carrying the AUTH_BASIC_USER and AUTH_BASIC_PASS
+// credentials. It admits nobody when they aren't configured.
+func (app *application) BasicAuthMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !app.config.auth.basic.enabled() {
+				return app.unauthorizedBasicErrorResponse(c, errors.New("basic auth is disabled"))
+			}
+
+			username, password, ok := c.Request().BasicAuth()
+			if !ok {
+				return app.unauthorizedBasicErrorResponse(c, errors.New("authorization header is missing"))
+			}
+
+			userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(app.config.auth.basic.user)) == 1
+			passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(app.config.auth.basic.pass)) == 1
+			if !userMatch || !passMatch {
+				return app.unauthorizedBasicErrorResponse(c, errors.New("invalid credentials"))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// getUserByIDParam loads the user named by the ":id" path parameter.
+func (app *application) getUserByIDParam(c echo.Context) (*store.User, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+
+	return app.store.Users.GetById(c.Request().Context(), id)
+}
+
+// unlockUserHandler lifts a login lockout before it runs out.
+func (app *application) unlockUserHandler(c echo.Context) error {
+	user, err := app.getUserByIDParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	ctx := c.Request().Context()
+
+	// The user may be locked out by the policy of the address they log in from as well
+	ipPolicy := app.config.loginGuard.IP
+	ips, err := app.store.LoginEvents.RecentIPs(ctx, user.ID, user.Email, time.Now().Add(-max(ipPolicy.Window, ipPolicy.MaxDelay)))
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.loginGuard.Unlock(ctx, user.Email, ips...); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.recordLoginEvent(c, user, user.Email, store.LoginUnlocked)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+const loginEventsLimit = 100
+
+func (app *application) getUserLoginEventsHandler(c echo.Context) error {
+	user, err := app.getUserByIDParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	events, err := app.store.LoginEvents.ListByUser(c.Request().Context(), user.ID, loginEventsLimit)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, events)
+}
+
+type AdminListQuery struct {
+	Search string `query:"q" validate:"max=200"`
+	Limit  int64  `query:"limit" validate:"omitempty,min=1,max=200"`
+	Offset int64  `query:"offset" validate:"omitempty,min=0"`
+}
+
+const adminListDefaultLimit = 50
+
+func (q *AdminListQuery) limit() int64 {
+	if q.Limit == 0 {
+		return adminListDefaultLimit
+	}
+	return q.Limit
+}
+
+// listUsersHandler lists every user, optionally searching usernames and emails.
+func (app *application) listUsersHandler(c echo.Context) error {
+	query, err := BindAndValidate[AdminListQuery](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	users, err := app.store.Users.List(c.Request().Context(), store.UserQuery{
+		Search: query.Search,
+		Limit:  query.limit(),
+		Offset: query.Offset,
+	})
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, users)
+}
+
+func (app *application) getUserHandler(c echo.Context) error {
+	user, err := app.getUserByIDParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	return app.jsonResponse(c, http.StatusOK, user)
+}
+
+// deactivateUserHandler blocks the user from signing in and ends every session,
+// their links keep working.
+func (app *application) deactivateUserHandler(c echo.Context) error {
+	return app.setUserActive(c, false)
+}
+
+func (app *application) activateUserHandler(c echo.Context) error {
+	return app.setUserActive(c, true)
+}
+
+func (app *application) setUserActive(c echo.Context, active bool) error {
+	user, err := app.getUserByIDParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if admin := getUserFromContext(c); !active && admin != nil && admin.ID == user.ID {
+		return app.badRequestResponse(c, errors.New("you can't deactivate your own account"))
+	}
+
+	ctx := c.Request().Context()
+
+	if err := app.store.Users.SetActive(ctx, user.ID, active); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if !active {
+		if err := app.revokeAllSessions(ctx, user.ID); err != nil {
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	action := "admin.user.activate"
+	if !active {
+		action = "admin.user.deactivate"
+	}
+
+	after := *user
+	after.IsActive = active
+	app.audit(c, auditEntry{Action: action, TargetType: auditTargetUser, TargetID: user.ID.Hex(), Before: user, After: &after})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type SetRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+// setUserRoleHandler takes effect on the user's next token, the admin scope is granted at login.
+func (app *application) setUserRoleHandler(c echo.Context) error {
+	payload, err := BindAndValidate[SetRolePayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	user, err := app.getUserByIDParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	ctx := c.Request().Context()
+
+	if err := app.store.Users.SetRole(ctx, user.ID, payload.Role); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	after := *user
+	after.Role = payload.Role
+	app.audit(c, auditEntry{Action: "admin.user.set_role", TargetType: auditTargetUser, TargetID: user.ID.Hex(), Before: user, After: &after})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AdminUrlsQuery struct {
+	AdminListQuery
+	UserID string `query:"user_id" validate:"omitempty,mongodb"`
+}
+
+// listAllUrlsHandler lists links of every user, optionally searching short codes and destinations.
+func (app *application) listAllUrlsHandler(c echo.Context) error {
+	query, err := BindAndValidate[AdminUrlsQuery](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	q := store.UrlQuery{
+		Search: query.Search,
+		Limit:  query.limit(),
+		Offset: query.Offset,
+	}
+	if query.UserID != "" {
+		q.UserID, _ = primitive.ObjectIDFromHex(query.UserID)
+	}
+
+	urls, err := app.store.Urls.List(c.Request().Context(), q)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, urls)
+}
+
+// forceDeleteUrlHandler deletes any link, e.g. one pointing to phishing or malware.
+func (app *application) forceDeleteUrlHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	shortURL, err := app.store.Urls.GetByShortCode(ctx, c.Param("shortCode"))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if err := app.store.Urls.Delete(ctx, shortURL.ShortCode); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.invalidateShortURL(ctx, shortURL.ShortCode)
+
+	if err := app.store.Clicks.DeleteByURLs(ctx, []primitive.ObjectID{shortURL.ID}); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.logger.Infow("link deleted by admin", "short_code", shortURL.ShortCode, "user", shortURL.UserID.Hex())
+
+	app.audit(c, auditEntry{Action: "admin.url.delete", TargetType: auditTargetURL, TargetID: shortURL.ShortCode, Workspaces: workspaceOf(shortURL), Before: shortURL})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// metricsHandler exposes the expvar metrics.
+var metricsHandler = echo.WrapHandler(expvar.Handler())
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// serveAdmin sends a request with the basic auth credentials, when given, through AdminMiddleware.
+func serveAdmin(t *testing.T, basic basicConfig, creds ...string) int {
+	t.Helper()
+
+	app := &application{logger: zap.NewNop().Sugar()}
+	app.config.auth.basic = basic
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	if len(creds) == 2 {
+		req.SetBasicAuth(creds[0], creds[1])
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	handler := app.AdminMiddleware()(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	if err := handler(c); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec.Code
+}
+
+func TestAdminBasicAuthNeedsExplicitCredentials(t *testing.T) {
+	for name, test := range map[string]struct {
+		basic basicConfig
+		creds []string
+	}{
+		"unset, old default":    {basicConfig{}, []string{"admin", "admin"}},
+		"unset, empty":          {basicConfig{}, []string{"", ""}},
+		"only user set":         {basicConfig{user: "ops"}, []string{"ops", ""}},
+		"default password":      {basicConfig{user: "admin", pass: "admin"}, []string{"admin", "admin"}},
+		"wrong password":        {basicConfig{user: "ops", pass: "s3cret"}, []string{"ops", "admin"}},
+		"no credentials at all": {basicConfig{user: "ops", pass: "s3cret"}, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if code := serveAdmin(t, test.basic, test.creds...); code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+			}
+		})
+	}
+
+	if code := serveAdmin(t, basicConfig{user: "ops", pass: "s3cret"}, "ops", "s3cret"); code != http.StatusOK {
+		t.Errorf("configured credentials: status = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/clicks"
+	"Url-Shortener/internal/geoip"
+	"Url-Shortener/internal/loginguard"
+	"Url-Shortener/internal/mailer"
 	"Url-Shortener/internal/ratelimiter"
+	"Url-Shortener/internal/sso"
 	"Url-Shortener/internal/store"
 	"Url-Shortener/internal/store/cache"
 	"context"
@@ -11,9 +16,11 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 	"log"
 	"net/http"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -24,7 +31,14 @@ type application struct {
 	cacheStorage  cache.Storage
 	logger        *zap.SugaredLogger
 	authenticator auth.Authenticator
-	rateLimiter   ratelimiter.Limiter
+	rateLimiters  map[string]ratelimiter.Limiter
+	clicks        *clicks.Recorder
+	geoip         geoip.Resolver
+	urlLookups    singleflight.Group
+	oidcProviders map[string]*sso.Provider
+	mailer        mailer.Mailer
+	loginGuard    *loginguard.Guard
+	tasks         sync.WaitGroup // Work finishing after its response was sent, see background
 }
 
 type config struct {
@@ -32,9 +46,17 @@ type config struct {
 	db          dbConfig
 	env         string
 	apiURL      string
+	frontendURL string
 	auth        authConfig
 	redisCfg    redisConfig
 	rateLimiter ratelimiter.Config
+	codes       codesConfig
+	urls        urlsConfig
+	clicks      clicks.Config
+	geoipDB     string
+	mail        mailConfig
+	loginGuard  loginguard.Config
+	audit       auditConfig
 }
 
 type dbConfig struct {
@@ -46,14 +68,35 @@ type dbConfig struct {
 }
 
 type authConfig struct {
-	basic basicConfig
-	token tokenConfig
+	basic      basicConfig
+	token      tokenConfig
+	refresh    refreshConfig
+	oidc       []sso.ProviderConfig
+	userTokens userTokensConfig
+	mfa        mfaConfig
 }
 
 type tokenConfig struct {
-	secret string
-	exp    time.Duration
-	iss    string
+	secret           string   // HS256 secret, used when no signing key is configured
+	signingKey       string   // PEM file with the RSA or Ed25519 private key tokens are signed with
+	verificationKeys []string // PEM files with previous public keys still accepted after a rotation
+	exp              time.Duration
+	iss              string
+}
+
+type refreshConfig struct {
+	exp time.Duration
+}
+
+type userTokensConfig struct {
+	verifyEmailExp   time.Duration
+	passwordResetExp time.Duration
+	invitationExp    time.Duration // Workspace invitations
+}
+
+type mfaConfig struct {
+	issuer       string        // Name authenticator apps show next to the code
+	challengeExp time.Duration // Time between the password and the code
 }
 
 type basicConfig struct {
@@ -61,6 +104,34 @@ type basicConfig struct {
 	pass string
 }
 
+// enabled reports whether both credentials were set, and not to the old admin:admin default.
+func (b basicConfig) enabled() bool {
+	return b.user != "" && b.pass != "" && b.pass != "admin"
+}
+
+type urlsConfig struct {
+	defaultExpiration time.Duration
+	maxExpiration     time.Duration
+	allowNever        bool
+	bulkMax           int // Most links one bulk request may create
+}
+
+type codesConfig struct {
+	generator string
+	nodeID    int64
+	blockSize int64
+}
+
+type mailConfig struct {
+	mailer    string // "smtp" or "outbox"
+	outboxDir string
+	smtp      mailer.SMTPConfig
+}
+
+type auditConfig struct {
+	retention time.Duration // How long audit events are kept
+}
+
 type redisConfig struct {
 	addr    string
 	pw      string
@@ -87,10 +158,8 @@ func (app *application) mount() http.Handler {
 		MaxAge:           300,
 	}))
 
-	// Optional Rate Limiting Middleware (if enabled in config)
-	if app.config.rateLimiter.Enabled {
-		e.Use(app.RateLimiterMiddleware)
-	}
+	// Public keys for services verifying our tokens
+	e.GET("/.well-known/jwks.json", app.jwksHandler, app.RateLimiterMiddleware(policyDefault, rateLimitByIP))
 
 	// -----------------------------
 	// API V1 Routes
@@ -98,32 +167,159 @@ func (app *application) mount() http.Handler {
 	v1 := e.Group("api/v1")
 
 	// Health check endpoint (no auth)
-	v1.GET("/health", app.healthCheckHandler)
+	v1.GET("/health", app.healthCheckHandler, app.RateLimiterMiddleware(policyDefault, rateLimitByIP))
 
 	// URL resource routes (with token auth)
 	url := v1.Group("/urls")
 	// Public routes (no auth)
-	url.GET("/:shortCode", app.getUrlHandler)
+	url.GET("/:shortCode", app.getUrlHandler, app.RateLimiterMiddleware(policyRedirect, rateLimitByIP))
+
+	// Authenticated routes are limited per IP before the credentials are checked, so
+	// forged tokens and guessed API keys can't be tried freely, then per user
+	authenticated := []echo.MiddlewareFunc{
+		app.RateLimiterMiddleware(policyDefault, rateLimitByIP),
+		app.AuthTokenMiddleware(),
+		app.RateLimiterMiddleware(policyUser, rateLimitByUser),
+	}
+
+	urlAuth := v1.Group("/urls", authenticated...)
+
+	urlAuth.GET("/", app.getAllUrlsByUserHandler, app.requireScopes(auth.ScopeUrlsRead))
+	urlAuth.POST("/shorten", app.createUrlHandler, app.requireScopes(auth.ScopeUrlsWrite), app.requireVerifiedEmail)
+	// About 4KB per item, a URL of up to 2048 bytes plus the other fields, so oversized bodies aren't decoded at all
+	bulkBodyLimit := middleware.BodyLimit(fmt.Sprintf("%dK", 4*app.config.urls.bulkMax))
+	urlAuth.POST("/bulk", app.bulkCreateUrlsHandler, bulkBodyLimit, app.requireScopes(auth.ScopeUrlsWrite), app.requireVerifiedEmail)
+	urlAuth.GET("/:shortCode/stats", app.getUrlStatsHandler, app.requireScopes(auth.ScopeStatsRead), app.checkUrlOwnership(store.WorkspaceRoleViewer))
+	urlAuth.PATCH("/:shortCode", app.updateUrlHandler, app.requireScopes(auth.ScopeUrlsWrite), app.requireVerifiedEmail, app.checkUrlOwnership(store.WorkspaceRoleEditor))
+	urlAuth.DELETE("/:shortCode", app.deleteUrlHandler, app.requireScopes(auth.ScopeUrlsDelete), app.checkUrlOwnership(store.WorkspaceRoleEditor))
+	urlAuth.GET("/:shortCode/transfers", app.getUrlTransfersHandler, app.requireScopes(auth.ScopeUrlsRead), app.checkUrlOwnership(store.WorkspaceRoleViewer))
+	urlAuth.POST("/:shortCode/transfers", app.createTransferHandler, app.requireScopes(auth.ScopeUrlsWrite), app.requireVerifiedEmail, app.checkUrlOwnership(store.WorkspaceRoleAdmin))
+
+	// Link transfers waiting for the recipient
+	transfers := v1.Group("/transfers", authenticated...)
+
+	transfers.GET("", app.getTransfersHandler, app.requireScopes(auth.ScopeUrlsRead))
+	transfers.POST("/:id/accept", app.acceptTransferHandler, app.requireScopes(auth.ScopeUrlsWrite))
+	transfers.POST("/:id/reject", app.rejectTransferHandler, app.requireScopes(auth.ScopeUrlsWrite))
+	transfers.POST("/:id/cancel", app.cancelTransferHandler, app.requireScopes(auth.ScopeUrlsWrite))
+
+	// Profile of the authenticated user
+	me := v1.Group("/users/me", authenticated...)
+
+	me.GET("", app.getCurrentUserHandler)
+	me.PATCH("", app.updateCurrentUserHandler, app.requireScopes(auth.ScopeAccountManage))
+	me.DELETE("", app.deleteCurrentUserHandler, app.requireScopes(auth.ScopeAccountManage))
+	me.POST("/password", app.changePasswordHandler, app.requireScopes(auth.ScopeAccountManage))
 
-	// Authenticated routes
-	urlAuth := v1.Group("/urls", app.AuthTokenMiddleware())
+	// Workspaces, links owned by a team rather than one user
+	workspaces := v1.Group("/workspaces", authenticated...)
 
-	urlAuth.GET("/", app.getAllUrlsByUserHandler)
-	urlAuth.POST("/shorten", app.createUrlHandler)
-	urlAuth.DELETE("/:shortCode", app.checkUrlOwnership(app.deleteUrlHandler))
+	workspaces.GET("", app.getWorkspacesHandler, app.requireScopes(auth.ScopeUrlsRead))
+	workspaces.POST("", app.createWorkspaceHandler, app.requireScopes(auth.ScopeAccountManage))
+	workspaces.POST("/invitations/accept", app.acceptInvitationHandler, app.requireScopes(auth.ScopeAccountManage), app.requireVerifiedEmail)
+
+	workspaces.GET("/:id", app.getWorkspaceHandler, app.requireScopes(auth.ScopeUrlsRead), app.requireWorkspaceRole(store.WorkspaceRoleViewer))
+	workspaces.PATCH("/:id", app.renameWorkspaceHandler, app.requireScopes(auth.ScopeAccountManage), app.requireWorkspaceRole(store.WorkspaceRoleAdmin))
+	workspaces.DELETE("/:id", app.deleteWorkspaceHandler, app.requireScopes(auth.ScopeAccountManage), app.requireWorkspaceRole(store.WorkspaceRoleOwner))
+	workspaces.GET("/:id/urls", app.getAllUrlsByWorkspaceHandler, app.requireScopes(auth.ScopeUrlsRead), app.requireWorkspaceRole(store.WorkspaceRoleViewer))
+
+	workspaces.GET("/:id/members", app.getWorkspaceMembersHandler, app.requireScopes(auth.ScopeUrlsRead), app.requireWorkspaceRole(store.WorkspaceRoleViewer))
+	workspaces.PATCH("/:id/members/:userId", app.updateWorkspaceMemberHandler, app.requireScopes(auth.ScopeAccountManage), app.requireWorkspaceRole(store.WorkspaceRoleAdmin))
+	workspaces.DELETE("/:id/members/:userId", app.removeWorkspaceMemberHandler, app.requireScopes(auth.ScopeAccountManage), app.requireWorkspaceRole(store.WorkspaceRoleViewer))
+
+	workspaces.GET("/:id/invitations", app.getInvitationsHandler, app.requireScopes(auth.ScopeAccountManage), app.requireWorkspaceRole(store.WorkspaceRoleAdmin))
+	workspaces.POST("/:id/invitations", app.createInvitationHandler, app.requireScopes(auth.ScopeAccountManage), app.requireVerifiedEmail, app.requireWorkspaceRole(store.WorkspaceRoleAdmin))
+	workspaces.DELETE("/:id/invitations/:invitationId", app.deleteInvitationHandler, app.requireScopes(auth.ScopeAccountManage), app.requireWorkspaceRole(store.WorkspaceRoleAdmin))
+
+	// Audit log of a workspace, for its admins
+	audit := v1.Group("/audit", authenticated...)
+	audit.GET("", app.getAuditLogHandler, app.requireScopes(auth.ScopeAccountManage))
+
+	// API keys
+	apiKeys := v1.Group("/api-keys", authenticated...)
+	apiKeys.Use(app.requireScopes(auth.ScopeKeysManage))
+
+	apiKeys.GET("", app.getAPIKeysHandler)
+	apiKeys.POST("", app.createAPIKeyHandler, app.requireVerifiedEmail)
+	apiKeys.DELETE("/:id", app.deleteAPIKeyHandler)
 
 	// -----------------------------
 	// Authentication Routes
 	// -----------------------------
 	authentication := v1.Group("/auth")
 
+	// The strict limit is for routes that check passwords, codes or send mail,
+	// routes used by signed in clients get the usual ones
+	strict := app.RateLimiterMiddleware(policyAuth, rateLimitByIP)
+	byIP := app.RateLimiterMiddleware(policyDefault, rateLimitByIP)
+
 	// Register and login
-	authentication.POST("/login", app.loginUserHandler)
-	authentication.POST("/register", app.registerUserHandle)
+	authentication.POST("/login", app.loginUserHandler, strict)
+	authentication.POST("/login/mfa", app.loginMFAHandler, strict)
+	authentication.POST("/register", app.registerUserHandle, strict)
+
+	// Sessions
+	authentication.POST("/refresh", app.refreshTokenHandler, byIP)
+	authentication.POST("/logout", app.logoutHandler, authenticated...)
+	authentication.POST("/logout-all", app.logoutAllHandler, authenticated...)
+
+	// Email verification and password reset
+	authentication.POST("/verify-email", app.verifyEmailHandler, byIP)
+	authentication.POST("/verify-email/resend", app.resendVerificationEmailHandler, authenticated...)
+	authentication.POST("/password-reset/request", app.requestPasswordResetHandler, strict)
+	authentication.POST("/password-reset/confirm", app.confirmPasswordResetHandler, strict)
+
+	// Two-factor authentication
+	mfa := authentication.Group("/mfa", strict, app.AuthTokenMiddleware(), app.requireScopes(auth.ScopeAccountManage))
+	mfa.POST("/enroll", app.enrollMFAHandler)
+	mfa.POST("/confirm", app.confirmMFAHandler)
+	mfa.POST("/disable", app.disableMFAHandler)
+
+	// External identity providers
+	authentication.GET("/oidc/:provider", app.oidcLoginHandler, strict)
+	authentication.GET("/oidc/:provider/callback", app.oidcCallbackHandler, strict)
+
+	// -----------------------------
+	// Admin Routes
+	// -----------------------------
+	admin := e.Group("/admin", byIP, app.AdminMiddleware(), app.RateLimiterMiddleware(policyUser, rateLimitByUser))
+
+	admin.GET("/metrics", metricsHandler)
+	admin.GET("/audit", app.adminAuditLogHandler)
+
+	admin.GET("/users", app.listUsersHandler)
+	admin.GET("/users/:id", app.getUserHandler)
+	admin.GET("/users/:id/login-events", app.getUserLoginEventsHandler)
+	admin.POST("/users/:id/unlock", app.unlockUserHandler)
+	admin.POST("/users/:id/deactivate", app.deactivateUserHandler)
+	admin.POST("/users/:id/activate", app.activateUserHandler)
+	admin.PUT("/users/:id/role", app.setUserRoleHandler)
+
+	admin.GET("/urls", app.listAllUrlsHandler)
+	admin.DELETE("/urls/:shortCode", app.forceDeleteUrlHandler)
+	admin.POST("/urls/:shortCode/transfer", app.forceTransferHandler)
 
 	return e
 }
 
+// background runs fn after the response, e.g. so its duration can't tell the
+// client anything. Shutdown waits for it.
+func (app *application) background(fn func()) {
+	app.tasks.Add(1)
+
+	go func() {
+		defer app.tasks.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Errorw("background task panicked", "error", err)
+			}
+		}()
+
+		fn()
+	}()
+}
+
 func (app *application) run(mux http.Handler) error {
 
 	server := &http.Server{
@@ -156,6 +352,16 @@ func (app *application) run(mux http.Handler) error {
 			log.Printf("Server forced to shutdown with error: %v", err)
 		}
 
+		// Requests are done, let the work they left behind finish
+		app.tasks.Wait()
+
+		// No more redirects can come in, write out the clicks still buffered
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer drainCancel()
+		if err := app.clicks.Close(drainCtx); err != nil {
+			log.Printf("Click recorder forced to stop with error: %v", err)
+		}
+
 		log.Println("Server exiting")
 
 		// Notify the main goroutine that the shutdown is complete
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/store"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxAPIKeysPerUser = 20
+
+type CreateAPIKeyPayload struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"omitempty,unique,dive,apikey_scope"` // Defaults to every scope an API key can hold
+}
+
+// createdAPIKey is only ever returned once, the plain key isn't stored.
+type createdAPIKey struct {
+	*store.APIKey
+	Key string `json:"key"`
+}
+
+func (app *application) createAPIKeyHandler(c echo.Context) error {
+	payload, err := BindAndValidate[CreateAPIKeyPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	user := getUserFromContext(c)
+	ctx := c.Request().Context()
+
+	keys, err := app.store.APIKeys.ListByUser(ctx, user.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+	if len(keys) >= maxAPIKeysPerUser {
+		return app.badRequestResponse(c, fmt.Errorf("a user can't have more than %d api keys", maxAPIKeysPerUser))
+	}
+
+	plain, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	scopes := payload.Scopes
+	if len(scopes) == 0 {
+		scopes = auth.APIKeyScopes
+	}
+
+	apiKey := &store.APIKey{
+		UserID:  user.ID,
+		Name:    payload.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  scopes,
+	}
+
+	if err := app.store.APIKeys.Create(ctx, apiKey); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.audit(c, auditEntry{Action: "api_key.create", TargetType: auditTargetAPIKey, TargetID: apiKey.ID.Hex(), After: apiKey})
+
+	return app.jsonResponse(c, http.StatusCreated, createdAPIKey{APIKey: apiKey, Key: plain})
+}
+
+func (app *application) getAPIKeysHandler(c echo.Context) error {
+	user := getUserFromContext(c)
+
+	keys, err := app.store.APIKeys.ListByUser(c.Request().Context(), user.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, keys)
+}
+
+func (app *application) deleteAPIKeyHandler(c echo.Context) error {
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return app.badRequestResponse(c, errors.New("invalid api key id"))
+	}
+
+	user := getUserFromContext(c)
+
+	if err := app.store.APIKeys.Delete(c.Request().Context(), user.ID, keyID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.audit(c, auditEntry{Action: "api_key.delete", TargetType: auditTargetAPIKey, TargetID: keyID.Hex()})
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"Url-Shortener/internal/store"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited resource types
+const (
+	auditTargetURL        = "url"
+	auditTargetUser       = "user"
+	auditTargetAPIKey     = "api_key"
+	auditTargetWorkspace  = "workspace"
+	auditTargetMember     = "workspace_member"
+	auditTargetInvitation = "workspace_invitation"
+	auditTargetTransfer   = "transfer"
+)
+
+// auditEntry describes a change for app.audit. Before and After are the
+// resource as the API returns it, nil when it didn't exist.
+type auditEntry struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	Workspaces []primitive.ObjectID
+	Before     any
+	After      any
+	Actor      *store.User // Defaults to the authenticated user
+}
+
+// audit records a change in the audit log. Failing to write it doesn't fail the request.
+func (app *application) audit(c echo.Context, entry auditEntry) {
+	event := app.auditEvent(c, entry)
+
+	if err := app.store.AuditEvents.Create(c.Request().Context(), event, app.config.audit.retention); err != nil {
+		app.logger.Errorw("failed to record audit event", "action", entry.Action, "target", entry.TargetID, "error", err.Error())
+	}
+}
+
+// auditMany is audit for several changes made by one request, written in one batch.
+func (app *application) auditMany(c echo.Context, entries []auditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	events := make([]*store.AuditEvent, len(entries))
+	for i, entry := range entries {
+		events[i] = app.auditEvent(c, entry)
+	}
+
+	if err := app.store.AuditEvents.CreateMany(c.Request().Context(), events, app.config.audit.retention); err != nil {
+		app.logger.Errorw("failed to record audit events", "action", entries[0].Action, "count", len(entries), "error", err.Error())
+	}
+}
+
+// auditEvent builds the audit log event of a change made by the request.
+func (app *application) auditEvent(c echo.Context, entry auditEntry) *store.AuditEvent {
+	before, after, err := auditDiff(entry.Before, entry.After)
+	if err != nil {
+		app.logger.Errorw("failed to diff audit event", "action", entry.Action, "error", err.Error())
+	}
+
+	event := &store.AuditEvent{
+		ActorType:    store.ActorUser,
+		Action:       entry.Action,
+		TargetType:   entry.TargetType,
+		TargetID:     entry.TargetID,
+		WorkspaceIDs: entry.Workspaces,
+		Before:       before,
+		After:        after,
+		RequestID:    c.Response().Header().Get(echo.HeaderXRequestID),
+		IP:           c.RealIP(),
+	}
+
+	actor := entry.Actor
+	if actor == nil {
+		actor = getUserFromContext(c)
+	}
+	if actor != nil {
+		event.ActorID = &actor.ID
+	} else {
+		// Only the admin routes can be used without a user
+		event.ActorType = store.ActorAdmin
+	}
+	if key, ok := c.Get("apiKey").(*store.APIKey); ok && key != nil {
+		event.APIKeyID = &key.ID
+	}
+
+	return event
+}
+
+// auditDiff returns the fields that differ between before and after, as they
+// appear in the API, so secrets kept out of responses stay out of the log too.
+func auditDiff(before, after any) (map[string]any, map[string]any, error) {
+	b, err := auditFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := auditFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b == nil || a == nil {
+		return b, a, nil
+	}
+
+	changedBefore := map[string]any{}
+	changedAfter := map[string]any{}
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changedBefore[k] = v
+		}
+	}
+	for k, v := range a {
+		if !reflect.DeepEqual(v, b[k]) {
+			changedAfter[k] = v
+		}
+	}
+
+	return changedBefore, changedAfter, nil
+}
+
+func auditFields(v any) (map[string]any, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil() {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// workspaceOf returns the workspace owning the link, if any, for auditEntry.Workspaces.
+func workspaceOf(shortURL *store.ShortURL) []primitive.ObjectID {
+	if shortURL.WorkspaceID == nil {
+		return nil
+	}
+	return []primitive.ObjectID{*shortURL.WorkspaceID}
+}
+
+type AuditLogQuery struct {
+	WorkspaceID string     `query:"workspace_id" validate:"omitempty,mongodb"`
+	ActorID     string     `query:"actor_id" validate:"omitempty,mongodb"`
+	Action      string     `query:"action" validate:"max=100"`
+	TargetType  string     `query:"target_type" validate:"max=100"`
+	TargetID    string     `query:"target_id" validate:"max=100"`
+	From        *time.Time `query:"from"`
+	To          *time.Time `query:"to"`
+	Cursor      string     `query:"cursor" validate:"omitempty,mongodb"`
+	Limit       int64      `query:"limit" validate:"omitempty,min=1,max=200"`
+}
+
+const auditDefaultLimit = 50
+
+type auditLogResponse struct {
+	Events     []store.AuditEvent `json:"events"`
+	NextCursor string             `json:"next_cursor,omitempty"` // Pass as cursor for the next page, empty on the last one
+}
+
+// toStoreQuery converts the query string, the IDs were validated already.
+func (q *AuditLogQuery) toStoreQuery() store.AuditQuery {
+	query := store.AuditQuery{
+		Action:     q.Action,
+		TargetType: q.TargetType,
+		TargetID:   q.TargetID,
+		From:       q.From,
+		To:         q.To,
+		Limit:      q.Limit,
+	}
+	if query.Limit == 0 {
+		query.Limit = auditDefaultLimit
+	}
+
+	query.WorkspaceID = optionalObjectID(q.WorkspaceID)
+	query.ActorID = optionalObjectID(q.ActorID)
+	query.Before = optionalObjectID(q.Cursor)
+
+	return query
+}
+
+func optionalObjectID(hex string) *primitive.ObjectID {
+	if hex == "" {
+		return nil
+	}
+	id, _ := primitive.ObjectIDFromHex(hex)
+	return &id
+}
+
+// getAuditLogHandler lists the audit events of a workspace, for its admins.
+func (app *application) getAuditLogHandler(c echo.Context) error {
+	query, err := BindAndValidate[AuditLogQuery](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	if query.WorkspaceID == "" {
+		return app.badRequestResponse(c, errors.New("workspace_id is required"))
+	}
+
+	q := query.toStoreQuery()
+	ctx := c.Request().Context()
+
+	admin, err := app.isWorkspaceAdmin(ctx, *q.WorkspaceID, getUserFromContext(c).ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+	if !admin {
+		return app.forbiddenResponse(c)
+	}
+
+	return app.listAuditEvents(c, q)
+}
+
+// adminAuditLogHandler lists the audit events of every user and workspace.
+func (app *application) adminAuditLogHandler(c echo.Context) error {
+	query, err := BindAndValidate[AuditLogQuery](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	return app.listAuditEvents(c, query.toStoreQuery())
+}
+
+func (app *application) listAuditEvents(c echo.Context, query store.AuditQuery) error {
+	events, err := app.store.AuditEvents.List(c.Request().Context(), query)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	res := auditLogResponse{Events: events}
+	if int64(len(events)) == query.Limit {
+		res.NextCursor = events[len(events)-1].ID.Hex()
+	}
+
+	return app.jsonResponse(c, http.StatusOK, res)
+}
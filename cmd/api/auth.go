@@ -1,10 +1,15 @@
 package main
 
 import (
+	"Url-Shortener/internal/auth"
 	"Url-Shortener/internal/store"
+	"context"
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
+	"slices"
 	"time"
 )
 
@@ -44,6 +49,13 @@ func (app *application) registerUserHandle(c echo.Context) error {
 		}
 	}
 
+	app.audit(c, auditEntry{Action: "user.register", TargetType: auditTargetUser, TargetID: user.ID.Hex(), After: user, Actor: user})
+
+	// The user can ask for another email if this one doesn't arrive
+	if err := app.sendVerificationEmail(ctx, user); err != nil {
+		app.logger.Errorw("failed to send verification email", "user", user.ID.Hex(), "error", err.Error())
+	}
+
 	return c.JSON(http.StatusCreated, user)
 }
 
@@ -60,37 +72,300 @@ func (app *application) loginUserHandler(c echo.Context) error {
 		return app.badRequestResponse(c, err)
 	}
 
-	user, err := app.store.Users.GetByEmail(c.Request().Context(), payload.Email)
+	ctx := c.Request().Context()
+
+	retryAfter, err := app.loginGuard.Attempt(ctx, payload.Email, c.RealIP())
+	if err != nil {
+		// Don't lock everyone out because the counters are unreachable
+		app.logger.Warnw("failed to check login attempts", "error", err.Error())
+	}
+	if retryAfter > 0 {
+		app.recordLoginEvent(c, nil, payload.Email, store.LoginLocked)
+		return app.tooManyLoginAttemptsResponse(c, retryAfter)
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
-			return app.unauthorizedErrorResponse(c, err)
+			return app.loginFailed(c, nil, payload.Email, err)
 		default:
+			app.releaseLoginAttempt(c, payload.Email)
 			return app.internalServerError(c, err)
 		}
 	}
 
 	if err := user.Password.Compare(payload.Password); err != nil {
-		return app.unauthorizedErrorResponse(c, err)
+		return app.loginFailed(c, user, payload.Email, err)
+	}
+
+	// With two-factor authentication the count is only reset once the code is right too,
+	// or the password step would wipe out failed codes
+	if user.MFA.Enabled {
+		app.releaseLoginAttempt(c, payload.Email)
+	} else if err := app.loginGuard.Succeed(ctx, payload.Email, c.RealIP()); err != nil {
+		app.logger.Warnw("failed to reset login attempts", "error", err.Error())
+	}
+
+	outcome := store.LoginSucceeded
+	switch {
+	case !user.IsActive:
+		outcome = store.LoginRefused
+	case user.MFA.Enabled:
+		outcome = store.LoginMFARequired
+	}
+	app.recordLoginEvent(c, user, payload.Email, outcome)
+
+	return app.startSession(c, user)
+}
+
+// loginFailed counts a wrong email or password towards the lockout of the account and the client IP.
+func (app *application) loginFailed(c echo.Context, user *store.User, email string, err error) error {
+	if _, err := app.loginGuard.Fail(c.Request().Context(), email, c.RealIP()); err != nil {
+		app.logger.Warnw("failed to record login attempt", "error", err.Error())
+	}
+
+	app.recordLoginEvent(c, user, email, store.LoginFailed)
+
+	return app.unauthorizedErrorResponse(c, err)
+}
+
+// releaseLoginAttempt settles a login attempt that neither failed nor succeeded.
+func (app *application) releaseLoginAttempt(c echo.Context, email string) {
+	if err := app.loginGuard.Release(c.Request().Context(), email, c.RealIP()); err != nil {
+		app.logger.Warnw("failed to release login attempt", "error", err.Error())
+	}
+}
+
+// recordLoginEvent adds to the login audit trail. Failing to write it doesn't fail the login.
+func (app *application) recordLoginEvent(c echo.Context, user *store.User, email, outcome string) {
+	event := &store.LoginEvent{
+		Email:     email,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Outcome:   outcome,
+	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+
+	if err := app.store.LoginEvents.Create(c.Request().Context(), event); err != nil {
+		app.logger.Errorw("failed to record login event", "email", email, "outcome", outcome, "error", err.Error())
+	}
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// issueTokens starts a new session for the user.
+func (app *application) issueTokens(ctx context.Context, user *store.User) (*tokenResponse, error) {
+	return app.issueSessionTokens(ctx, user, time.Now(), func(refresh *store.RefreshToken) error {
+		refresh.UserID = user.ID
+		refresh.FamilyID = primitive.NewObjectID()
+		return app.store.RefreshTokens.Create(ctx, refresh)
+	})
+}
+
+// issueSessionTokens creates an access token plus a refresh token, saving the
+// latter through save so callers can decide whether it starts or continues a session.
+// authTime is when the user signed in to the session.
+func (app *application) issueSessionTokens(ctx context.Context, user *store.User, authTime time.Time, save func(*store.RefreshToken) error) (*tokenResponse, error) {
+	accessToken, err := app.generateAccessToken(user, authTime)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := &store.RefreshToken{
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(app.config.auth.refresh.exp),
+		AuthTime:  authTime,
+	}
+	if err := save(refresh); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: plain,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(app.config.auth.token.exp.Seconds()),
+	}, nil
+}
+
+func (app *application) generateAccessToken(user *store.User, authTime time.Time) (string, error) {
+	jti, err := auth.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	scopes := auth.UserScopes
+	if user.Role == store.RoleAdmin {
+		scopes = append(slices.Clone(scopes), auth.ScopeAdmin)
 	}
 
 	claims := jwt.MapClaims{
-		"sub": user.ID.Hex(),
-		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
-		"iss": app.config.auth.token.iss,
-		"aud": app.config.auth.token.iss,
+		"sub":   user.ID.Hex(),
+		"exp":   now.Add(app.config.auth.token.exp).Unix(),
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"iss":   app.config.auth.token.iss,
+		"aud":   app.config.auth.token.iss,
+		"jti":   jti,
+		"scope": auth.FormatScopes(scopes),
+	}
+	if !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
+	return app.authenticator.GenerateToken(claims)
+}
+
+// denylist picks where revoked access tokens are kept.
+func (app *application) denylist() store.TokenDenylist {
+	if app.config.redisCfg.enabled {
+		return app.cacheStorage.RevokedTokens
+	}
+	return app.store.RevokedTokens
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (app *application) refreshTokenHandler(c echo.Context) error {
+	payload, err := BindAndValidate[RefreshTokenPayload](c)
+
 	if err != nil {
-		return app.internalServerError(c, err)
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	current, err := app.store.RefreshTokens.GetByHash(ctx, auth.HashToken(payload.RefreshToken))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.unauthorizedErrorResponse(c, errors.New("unknown refresh token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if current.RevokedAt != nil {
+		// A rotated token showing up again means it leaked, end the whole session
+		if err := app.store.RefreshTokens.RevokeFamily(ctx, current.FamilyID); err != nil {
+			return app.internalServerError(c, err)
+		}
+		return app.unauthorizedErrorResponse(c, store.ErrTokenReused)
 	}
 
-	if err := app.jsonResponse(c, http.StatusCreated, token); err != nil {
+	if time.Now().After(current.ExpiresAt) {
+		return app.unauthorizedErrorResponse(c, errors.New("refresh token expired"))
+	}
+
+	user, err := app.store.Users.GetById(ctx, current.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.unauthorizedErrorResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if !user.IsActive {
+		return app.accountDeactivatedResponse(c)
+	}
+
+	tokens, err := app.issueSessionTokens(ctx, user, current.AuthTime, func(next *store.RefreshToken) error {
+		return app.store.RefreshTokens.Rotate(ctx, current, next)
+	})
+	if err != nil {
+		switch err {
+		case store.ErrTokenReused:
+			if err := app.store.RefreshTokens.RevokeFamily(ctx, current.FamilyID); err != nil {
+				return app.internalServerError(c, err)
+			}
+			return app.unauthorizedErrorResponse(c, store.ErrTokenReused)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	return app.jsonResponse(c, http.StatusOK, tokens)
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// logoutHandler revokes the access token used for the request and,
+// when given, the session of the refresh token.
+func (app *application) logoutHandler(c echo.Context) error {
+	payload, err := BindAndValidate[LogoutPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+
+	if claims := getClaimsFromContext(c); claims != nil {
+		jti, _ := claims["jti"].(string)
+		exp, err := claims.GetExpirationTime()
+		if jti != "" && err == nil && exp != nil {
+			if err := app.denylist().RevokeToken(ctx, jti, exp.Time); err != nil {
+				return app.internalServerError(c, err)
+			}
+		}
+	}
+
+	if payload.RefreshToken != "" {
+		refresh, err := app.store.RefreshTokens.GetByHash(ctx, auth.HashToken(payload.RefreshToken))
+		switch {
+		case err == nil && refresh.UserID == user.ID:
+			if err := app.store.RefreshTokens.RevokeFamily(ctx, refresh.FamilyID); err != nil {
+				return app.internalServerError(c, err)
+			}
+		case err != nil && !errors.Is(err, store.ErrNotFound):
+			return app.internalServerError(c, err)
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// logoutAllHandler ends every session of the user, on every device.
+func (app *application) logoutAllHandler(c echo.Context) error {
+	user := getUserFromContext(c)
+
+	if err := app.revokeAllSessions(c.Request().Context(), user.ID); err != nil {
 		return app.internalServerError(c, err)
 	}
 
-	return nil
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *application) revokeAllSessions(ctx context.Context, userID primitive.ObjectID) error {
+	if err := app.store.RefreshTokens.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+
+	// "iat" only has second precision, truncate so tokens issued right after
+	// this call, in the same second, stay valid
+	before := time.Now().Truncate(time.Second)
+
+	return app.denylist().RevokeUserTokens(ctx, userID, before, app.config.auth.token.exp)
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) internalServerError(c echo.Context, err error) error {
@@ -15,6 +17,16 @@ func (app *application) forbiddenResponse(c echo.Context) error {
 	return writeJSONError(c, http.StatusForbidden, "forbidden")
 }
 
+func (app *application) emailNotVerifiedResponse(c echo.Context) error {
+	app.logger.Warnw("email not verified", "method", c.Request().Method, "path", c.Path())
+	return writeJSONError(c, http.StatusForbidden, "confirm your email address first")
+}
+
+func (app *application) accountDeactivatedResponse(c echo.Context) error {
+	app.logger.Warnw("account deactivated", "method", c.Request().Method, "path", c.Path())
+	return writeJSONError(c, http.StatusForbidden, "account is deactivated")
+}
+
 func (app *application) badRequestResponse(c echo.Context, err error) error {
 	app.logger.Warnw("bad request", "method", c.Request().Method, "path", c.Path(), "error", err.Error())
 	return writeJSONError(c, http.StatusBadRequest, err.Error())
@@ -30,6 +42,11 @@ func (app *application) notFoundResponse(c echo.Context, err error) error {
 	return writeJSONError(c, http.StatusNotFound, "not found")
 }
 
+func (app *application) goneResponse(c echo.Context, err error) error {
+	app.logger.Warnw("gone", "method", c.Request().Method, "path", c.Path(), "error", err.Error())
+	return writeJSONError(c, http.StatusGone, "gone")
+}
+
 func (app *application) unauthorizedErrorResponse(c echo.Context, err error) error {
 	app.logger.Warnw("unauthorized error", "method", c.Request().Method, "path", c.Path(), "error", err.Error())
 	return writeJSONError(c, http.StatusUnauthorized, "unauthorized")
@@ -41,9 +58,16 @@ func (app *application) unauthorizedBasicErrorResponse(c echo.Context, err error
 	return writeJSONError(c, http.StatusUnauthorized, "unauthorized")
 }
 
-func (app *application) rateLimitExceededResponse(c echo.Context, retryAfter string) error {
+func (app *application) rateLimitExceededResponse(c echo.Context, retryAfter time.Duration) error {
 	app.logger.Warnw("rate limit exceeded", "method", c.Request().Method, "path", c.Path())
-	c.Response().Header().Set("Retry-After", retryAfter)
-	return writeJSONError(c, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter)
+	seconds := strconv.Itoa(max(1, ceilSeconds(retryAfter)))
+	c.Response().Header().Set("Retry-After", seconds)
+	return writeJSONError(c, http.StatusTooManyRequests, "rate limit exceeded, retry after "+seconds+" seconds")
+}
+
+func (app *application) tooManyLoginAttemptsResponse(c echo.Context, retryAfter time.Duration) error {
+	app.logger.Warnw("login locked", "method", c.Request().Method, "path", c.Path(), "ip", c.RealIP())
+	seconds := strconv.Itoa(max(1, ceilSeconds(retryAfter)))
+	c.Response().Header().Set("Retry-After", seconds)
+	return writeJSONError(c, http.StatusTooManyRequests, "too many failed login attempts, retry after "+seconds+" seconds")
 }
-	
\ No newline at end of file
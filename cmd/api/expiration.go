@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errExpirationAmbiguous = errors.New("only one of expires_at, expires_in and never can be set")
+	errExpirationInPast    = errors.New("expiration must be in the future")
+	errNeverExpireDisabled = errors.New("links that never expire are not allowed")
+)
+
+// ExpirationPayload lets clients pick when a link expires. At most one field may be set,
+// when none is the server default applies.
+type ExpirationPayload struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn string     `json:"expires_in"` // Go duration ("36h") or whole days ("30d")
+	Never     bool       `json:"never"`
+}
+
+func (p ExpirationPayload) isSet() bool {
+	return p.ExpiresAt != nil || p.ExpiresIn != "" || p.Never
+}
+
+// resolveExpiration turns the payload into an absolute expiration, nil meaning never.
+func (app *application) resolveExpiration(p ExpirationPayload, now time.Time) (*time.Time, error) {
+	set := 0
+	for _, ok := range []bool{p.ExpiresAt != nil, p.ExpiresIn != "", p.Never} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errExpirationAmbiguous
+	}
+
+	var expiresAt time.Time
+
+	switch {
+	case p.Never:
+		if !app.config.urls.allowNever {
+			return nil, errNeverExpireDisabled
+		}
+		return nil, nil
+	case p.ExpiresAt != nil:
+		expiresAt = *p.ExpiresAt
+	case p.ExpiresIn != "":
+		d, err := parseExpiresIn(p.ExpiresIn)
+		if err != nil {
+			return nil, err
+		}
+		expiresAt = now.Add(d)
+	default:
+		expiresAt = now.Add(app.config.urls.defaultExpiration)
+	}
+
+	if !expiresAt.After(now) {
+		return nil, errExpirationInPast
+	}
+	if limit := app.config.urls.maxExpiration; limit > 0 && expiresAt.Sub(now) > limit {
+		return nil, fmt.Errorf("expiration can't be more than %s away", limit)
+	}
+
+	expiresAt = expiresAt.UTC()
+	return &expiresAt, nil
+}
+
+// maxExpiresInDays is the most days a time.Duration can hold.
+const maxExpiresInDays = math.MaxInt64 / int64(24*time.Hour)
+
+func parseExpiresIn(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		// Bounded so the multiplication can't wrap around into a short, allowed duration
+		if err != nil || n <= 0 || n > maxExpiresInDays {
+			return 0, fmt.Errorf("invalid expires_in %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid expires_in %q", s)
+	}
+
+	return d, nil
+}
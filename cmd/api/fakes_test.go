@@ -0,0 +1,282 @@
+package main
+
+import (
+	"Url-Shortener/internal/store"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// In-memory stand-ins for the stores handler tests go through. Methods
+// the tests don't need fail with errNotFaked.
+
+var errNotFaked = errors.New("not implemented by the fake store")
+
+type fakeUsers struct {
+	mu    sync.Mutex
+	users map[primitive.ObjectID]*store.User
+
+	beforeCreate func() // Runs once before the next Create, to race it
+
+	mfaSteps      []int64 // Steps passed to UseMFAStep
+	recoveryTries int     // Calls to UseRecoveryCode, none of which match
+}
+
+func newFakeUsers(users ...*store.User) *fakeUsers {
+	f := &fakeUsers{users: make(map[primitive.ObjectID]*store.User)}
+	for _, user := range users {
+		if user.ID.IsZero() {
+			user.ID = primitive.NewObjectID()
+		}
+		f.users[user.ID] = user
+	}
+	return f
+}
+
+// add stores user as it is, like a registration through another replica.
+func (f *fakeUsers) add(user *store.User) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	f.users[user.ID] = user
+}
+
+func (f *fakeUsers) Create(_ context.Context, user *store.User) error {
+	if race := f.beforeCreate; race != nil {
+		f.beforeCreate = nil
+		race()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Normalized and then compared exactly, like UserStore and its unique index
+	user.Email = store.NormalizeEmail(user.Email)
+	for _, existing := range f.users {
+		if existing.Email == user.Email {
+			return store.ErrDuplicateEmail
+		}
+		if existing.Username == user.Username {
+			return store.ErrDuplicateUsername
+		}
+	}
+
+	user.ID = primitive.NewObjectID()
+	user.CreatedAt = time.Now()
+	user.IsActive = true
+	if user.Role == "" {
+		user.Role = store.RoleUser
+	}
+
+	copied := *user
+	f.users[user.ID] = &copied
+	return nil
+}
+
+func (f *fakeUsers) GetById(_ context.Context, id primitive.ObjectID) (*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user, ok := f.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUsers) GetByEmail(_ context.Context, email string) (*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, user := range f.users {
+		if user.Email == store.NormalizeEmail(email) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (f *fakeUsers) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.users)
+}
+
+func (f *fakeUsers) Update(context.Context, *store.User) error                  { return errNotFaked }
+func (f *fakeUsers) Delete(context.Context, primitive.ObjectID) error           { return errNotFaked }
+func (f *fakeUsers) SetEmailVerified(context.Context, primitive.ObjectID) error { return errNotFaked }
+func (f *fakeUsers) UpdatePassword(context.Context, *store.User) error          { return errNotFaked }
+func (f *fakeUsers) SetMFA(context.Context, primitive.ObjectID, store.MFA) error {
+	return errNotFaked
+}
+func (f *fakeUsers) List(context.Context, store.UserQuery) ([]store.User, error) {
+	return nil, errNotFaked
+}
+func (f *fakeUsers) SetActive(context.Context, primitive.ObjectID, bool) error { return errNotFaked }
+func (f *fakeUsers) SetRole(context.Context, primitive.ObjectID, string) error { return errNotFaked }
+func (f *fakeUsers) UseMFAStep(_ context.Context, _ primitive.ObjectID, step int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.mfaSteps = append(f.mfaSteps, step)
+	return nil
+}
+func (f *fakeUsers) UseRecoveryCode(context.Context, primitive.ObjectID, string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.recoveryTries++
+	return store.ErrNotFound
+}
+
+type fakeOIDCStates struct {
+	mu     sync.Mutex
+	states map[string]store.OIDCState
+}
+
+func newFakeOIDCStates() *fakeOIDCStates {
+	return &fakeOIDCStates{states: make(map[string]store.OIDCState)}
+}
+
+func (f *fakeOIDCStates) Create(_ context.Context, state *store.OIDCState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state.CreatedAt = time.Now()
+	f.states[state.State] = *state
+	return nil
+}
+
+func (f *fakeOIDCStates) Consume(_ context.Context, provider, state string) (*store.OIDCState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored, ok := f.states[state]
+	if !ok || stored.Provider != provider || !stored.ExpiresAt.After(time.Now()) {
+		return nil, store.ErrNotFound
+	}
+	delete(f.states, state)
+	return &stored, nil
+}
+
+func (f *fakeOIDCStates) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.states)
+}
+
+type fakeIdentities struct {
+	mu         sync.Mutex
+	identities []store.Identity
+}
+
+func (f *fakeIdentities) Create(_ context.Context, identity *store.Identity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, existing := range f.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return store.ErrDuplicateIdentity
+		}
+	}
+
+	identity.ID = primitive.NewObjectID()
+	identity.CreatedAt = time.Now()
+	f.identities = append(f.identities, *identity)
+	return nil
+}
+
+func (f *fakeIdentities) Get(_ context.Context, provider, subject string) (*store.Identity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, identity := range f.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (f *fakeIdentities) DeleteAllByUser(context.Context, primitive.ObjectID) error {
+	return errNotFaked
+}
+
+func (f *fakeIdentities) all() []store.Identity {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]store.Identity(nil), f.identities...)
+}
+
+type fakeAuditEvents struct {
+	mu     sync.Mutex
+	events []store.AuditEvent
+}
+
+func (f *fakeAuditEvents) Create(_ context.Context, event *store.AuditEvent, retention time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+	event.ExpiresAt = event.CreatedAt.Add(retention)
+	f.events = append(f.events, *event)
+	return nil
+}
+
+func (f *fakeAuditEvents) CreateMany(ctx context.Context, events []*store.AuditEvent, retention time.Duration) error {
+	for _, event := range events {
+		f.Create(ctx, event, retention)
+	}
+	return nil
+}
+
+func (f *fakeAuditEvents) List(context.Context, store.AuditQuery) ([]store.AuditEvent, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeAuditEvents) actions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	actions := make([]string, len(f.events))
+	for i, event := range f.events {
+		actions[i] = event.Action
+	}
+	return actions
+}
+
+type fakeRefreshTokens struct {
+	mu     sync.Mutex
+	tokens []store.RefreshToken
+}
+
+func (f *fakeRefreshTokens) Create(_ context.Context, token *store.RefreshToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token.ID = primitive.NewObjectID()
+	f.tokens = append(f.tokens, *token)
+	return nil
+}
+
+func (f *fakeRefreshTokens) GetByHash(context.Context, string) (*store.RefreshToken, error) {
+	return nil, errNotFaked
+}
+func (f *fakeRefreshTokens) Rotate(context.Context, *store.RefreshToken, *store.RefreshToken) error {
+	return errNotFaked
+}
+func (f *fakeRefreshTokens) RevokeFamily(context.Context, primitive.ObjectID) error {
+	return errNotFaked
+}
+func (f *fakeRefreshTokens) RevokeAllForUser(context.Context, primitive.ObjectID) error {
+	return errNotFaked
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"Url-Shortener/internal/auth"
 	"encoding/json"
 	"net/http"
 
@@ -12,6 +13,13 @@ var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+
+	Validate.RegisterValidation("alias", func(fl validator.FieldLevel) bool {
+		return aliasPattern.MatchString(fl.Field().String())
+	})
+	Validate.RegisterValidation("apikey_scope", func(fl validator.FieldLevel) bool {
+		return auth.IsAPIKeyScope(fl.Field().String())
+	})
 }
 
 func readJSON(c echo.Context, data any) error {
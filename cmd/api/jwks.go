@@ -0,0 +1,14 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jwksHandler publishes the public keys access tokens are signed with, in the
+// plain JWKS format verifiers expect rather than our usual data envelope.
+func (app *application) jwksHandler(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, app.authenticator.KeySet())
+}
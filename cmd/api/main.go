@@ -2,14 +2,23 @@ package main
 
 import (
 	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/clicks"
 	"Url-Shortener/internal/database"
 	"Url-Shortener/internal/env"
+	"Url-Shortener/internal/geoip"
+	"Url-Shortener/internal/idgen"
+	"Url-Shortener/internal/loginguard"
+	"Url-Shortener/internal/mailer"
 	"Url-Shortener/internal/ratelimiter"
+	"Url-Shortener/internal/sso"
 	"Url-Shortener/internal/store"
 	"Url-Shortener/internal/store/cache"
 	"context"
+	"crypto"
 	"expvar"
+	"fmt"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -20,8 +29,9 @@ const version = "1.1.0"
 
 func main() {
 	cfg := config{
-		addr:   env.GetString("PORT", ":8080"),
-		apiURL: env.GetString("API_URL", "localhost:8080"),
+		addr:        env.GetString("PORT", ":8080"),
+		apiURL:      env.GetString("API_URL", "localhost:8080"),
+		frontendURL: env.GetString("FRONTEND_URL", "http://localhost:3000"),
 		db: dbConfig{
 			host:     env.GetString("DB_HOST", "localhost"),
 			port:     env.GetString("DB_PORT", "27017"),
@@ -38,19 +48,97 @@ func main() {
 		env: env.GetString("ENV", "development"),
 		auth: authConfig{
 			basic: basicConfig{
-				user: env.GetString("AUTH_BASIC_USER", "admin"),
-				pass: env.GetString("AUTH_BASIC_PASS", "admin"),
+				user: env.GetString("AUTH_BASIC_USER", ""),
+				pass: env.GetString("AUTH_BASIC_PASS", ""),
 			},
 			token: tokenConfig{
-				secret: env.GetString("AUTH_TOKEN_SECRET", "example"),
-				exp:    time.Hour * 24 * 3, // 3 days
-				iss:    "gophersocial",
+				secret:           env.GetString("AUTH_TOKEN_SECRET", "example"),
+				signingKey:       env.GetString("AUTH_TOKEN_SIGNING_KEY", ""),
+				verificationKeys: env.GetStrings("AUTH_TOKEN_VERIFICATION_KEYS", nil),
+				exp:              env.GetDuration("AUTH_TOKEN_EXP", time.Minute*15),
+				iss:              "gophersocial",
+			},
+			refresh: refreshConfig{
+				exp: env.GetDuration("AUTH_REFRESH_TOKEN_EXP", time.Hour*24*30), // 30 days
+			},
+			oidc: oidcProvidersConfig(env.GetStrings("OIDC_PROVIDERS", nil)),
+			userTokens: userTokensConfig{
+				verifyEmailExp:   env.GetDuration("AUTH_VERIFY_EMAIL_EXP", time.Hour*24),
+				passwordResetExp: env.GetDuration("AUTH_PASSWORD_RESET_EXP", time.Hour),
+				invitationExp:    env.GetDuration("WORKSPACE_INVITATION_EXP", time.Hour*24*7), // 7 days
+			},
+			mfa: mfaConfig{
+				issuer:       env.GetString("MFA_ISSUER", "URL Shortener"),
+				challengeExp: env.GetDuration("MFA_CHALLENGE_EXP", time.Minute*5),
 			},
 		},
 		rateLimiter: ratelimiter.Config{
 			RequestsPerTimeFrame: env.GetInt("RATELIMITER_REQUESTS_COUNT", 20),
 			TimeFrame:            time.Second * 5,
 			Enabled:              env.GetBool("RATE_LIMITER_ENABLED", true),
+			Backend:              env.GetString("RATE_LIMITER_BACKEND", ratelimiter.BackendMemory),
+			Algorithm:            env.GetString("RATE_LIMITER_ALGORITHM", ratelimiter.AlgorithmSlidingWindow),
+			Policies: map[string]ratelimiter.Policy{
+				policyAuth: {
+					RequestsPerTimeFrame: env.GetInt("RATELIMITER_AUTH_REQUESTS_COUNT", 10),
+					TimeFrame:            time.Minute,
+				},
+				policyRedirect: {
+					RequestsPerTimeFrame: env.GetInt("RATELIMITER_REDIRECT_REQUESTS_COUNT", 100),
+					TimeFrame:            time.Second * 5,
+				},
+				policyUser: {
+					RequestsPerTimeFrame: env.GetInt("RATELIMITER_USER_REQUESTS_COUNT", 120),
+					TimeFrame:            time.Minute,
+				},
+			},
+		},
+		codes: codesConfig{
+			generator: env.GetString("CODE_GENERATOR", "snowflake"),
+			nodeID:    int64(env.GetInt("MACHINE_ID", 0)),
+			blockSize: int64(env.GetInt("CODE_BLOCK_SIZE", 1000)),
+		},
+		urls: urlsConfig{
+			defaultExpiration: env.GetDuration("URL_DEFAULT_EXPIRATION", time.Hour*24*15), // 15 days
+			maxExpiration:     env.GetDuration("URL_MAX_EXPIRATION", time.Hour*24*365),    // 1 year
+			allowNever:        env.GetBool("URL_ALLOW_NEVER_EXPIRE", false),
+			bulkMax:           env.GetInt("URL_BULK_MAX", 500),
+		},
+		clicks: clicks.Config{
+			QueueSize:      env.GetInt("CLICKS_QUEUE_SIZE", 10_000),
+			BatchSize:      env.GetInt("CLICKS_BATCH_SIZE", 500),
+			FlushInterval:  env.GetDuration("CLICKS_FLUSH_INTERVAL", time.Second),
+			EnqueueTimeout: env.GetDuration("CLICKS_ENQUEUE_TIMEOUT", 10*time.Millisecond),
+			Workers:        env.GetInt("CLICKS_WORKERS", 2),
+		},
+		geoipDB: env.GetString("GEOIP_DB_PATH", ""),
+		loginGuard: loginguard.Config{
+			Account: loginguard.Policy{
+				FreeAttempts: env.GetInt("LOGIN_MAX_ATTEMPTS", 5),
+				BaseDelay:    env.GetDuration("LOGIN_LOCKOUT_BASE", time.Second*30),
+				MaxDelay:     env.GetDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+				Window:       env.GetDuration("LOGIN_ATTEMPTS_WINDOW", time.Minute*15),
+			},
+			IP: loginguard.Policy{
+				FreeAttempts: env.GetInt("LOGIN_IP_MAX_ATTEMPTS", 50),
+				BaseDelay:    env.GetDuration("LOGIN_LOCKOUT_BASE", time.Second*30),
+				MaxDelay:     env.GetDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+				Window:       env.GetDuration("LOGIN_ATTEMPTS_WINDOW", time.Minute*15),
+			},
+		},
+		audit: auditConfig{
+			retention: env.GetDuration("AUDIT_RETENTION", time.Hour*24*365), // 1 year
+		},
+		mail: mailConfig{
+			mailer:    env.GetString("MAILER", "outbox"),
+			outboxDir: env.GetString("MAIL_OUTBOX_DIR", "outbox"),
+			smtp: mailer.SMTPConfig{
+				Host:     env.GetString("SMTP_HOST", "localhost"),
+				Port:     env.GetInt("SMTP_PORT", 587),
+				Username: env.GetString("SMTP_USERNAME", ""),
+				Password: env.GetString("SMTP_PASSWORD", ""),
+				From:     env.GetString("MAIL_FROM", "URL Shortener <no-reply@localhost>"),
+			},
 		},
 	}
 	logger := zap.Must(zap.NewProduction()).Sugar()
@@ -84,29 +172,115 @@ func main() {
 		defer rdb.Close()
 	}
 
-	// Rate limiter
-	rateLimiter := ratelimiter.NewFixedWindowLimiter(
-		cfg.rateLimiter.RequestsPerTimeFrame,
-		cfg.rateLimiter.TimeFrame,
-	)
+	// Rate limiters, one per policy
+	rateLimiters := make(map[string]ratelimiter.Limiter)
+	for _, policy := range []string{policyDefault, policyAuth, policyRedirect, policyUser} {
+		limiter, err := newRateLimiter(cfg, policy, rdb)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		rateLimiters[policy] = limiter
+	}
+	if cfg.rateLimiter.Backend == ratelimiter.BackendRedis && !cfg.redisCfg.enabled {
+		logger.Warn("redis rate limiter requested but redis is disabled, using in-memory rate limiter")
+	}
+
+	// Failed login counters, shared between replicas through Redis when it's enabled
+	var loginAttempts loginguard.Store
+	if cfg.redisCfg.enabled {
+		loginAttempts = loginguard.NewRedisStore(rdb)
+	} else {
+		memoryAttempts := loginguard.NewMemoryStore()
+		defer memoryAttempts.Stop()
+
+		loginAttempts = memoryAttempts
+	}
+
+	// Whatever the environment, the admin routes never take well-known credentials
+	if !cfg.auth.basic.enabled() && (cfg.auth.basic.user != "" || cfg.auth.basic.pass != "") {
+		logger.Warn("basic auth for the admin routes is disabled, AUTH_BASIC_USER and AUTH_BASIC_PASS must both be set and the password can't be \"admin\"")
+	}
 
 	// Authenticator
-	jwtAuthenticator := auth.NewJWTAuthenticator(
-		cfg.auth.token.secret,
-		cfg.auth.token.iss,
-		cfg.auth.token.iss,
-	)
+	jwtAuthenticator, err := newAuthenticator(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-	storage := store.NewStorage(db.Database(cfg.db.dbName))
+	// Short code generator
+	var ids idgen.Generator
+	switch cfg.codes.generator {
+	case "block":
+		ids, err = idgen.NewBlockAllocator(
+			store.NewCounterStore(db.Database(cfg.db.dbName)),
+			"short_codes",
+			cfg.codes.blockSize,
+		)
+	case "snowflake":
+		ids, err = idgen.NewSnowflake(idgen.SnowflakeConfig{
+			NodeID: cfg.codes.nodeID,
+		})
+	default:
+		err = fmt.Errorf("unknown code generator %q", cfg.codes.generator)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	storage := store.NewStorage(db.Database(cfg.db.dbName), store.NewBase62CodeGenerator(ids))
 	cacheStorage := cache.NewRedisStorage(rdb)
 
+	// GeoIP, countries are left empty when no database is configured
+	var geoResolver geoip.Resolver = geoip.NopResolver{}
+	if cfg.geoipDB != "" {
+		mmdb, err := geoip.Open(cfg.geoipDB)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer mmdb.Close()
+
+		geoResolver = mmdb
+		logger.Info("geoip database loaded")
+	}
+
+	// OpenID Connect providers, metadata is fetched on first use
+	oidcProviders := make(map[string]*sso.Provider)
+	for _, providerCfg := range cfg.auth.oidc {
+		oidcProviders[providerCfg.Name] = sso.NewProvider(providerCfg)
+	}
+
+	// Mailer
+	var mail mailer.Mailer
+	switch cfg.mail.mailer {
+	case "smtp":
+		mail, err = mailer.NewSMTPMailer(cfg.mail.smtp)
+	case "outbox":
+		mail, err = mailer.NewOutboxMailer(cfg.mail.outboxDir, cfg.mail.smtp.From)
+		if cfg.env != "development" {
+			logger.Warnw("emails are written to the outbox directory instead of being sent", "dir", cfg.mail.outboxDir)
+		}
+	default:
+		err = fmt.Errorf("unknown mailer %q", cfg.mail.mailer)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// Click recorder
+	clickRecorder := clicks.NewRecorder(storage, cfg.clicks, logger)
+
 	app := &application{
 		config:        cfg,
 		store:         storage,
 		cacheStorage:  cacheStorage,
 		logger:        logger,
 		authenticator: jwtAuthenticator,
-		rateLimiter:   rateLimiter,
+		rateLimiters:  rateLimiters,
+		clicks:        clickRecorder,
+		geoip:         geoResolver,
+		oidcProviders: oidcProviders,
+		mailer:        mail,
+		loginGuard:    loginguard.New(loginAttempts, cfg.loginGuard),
 	}
 
 	// Metrics collected
@@ -128,3 +302,68 @@ func main() {
 
 	logger.Fatal(app.run(mux))
 }
+
+// newAuthenticator signs tokens with the configured private key, or falls back
+// to HS256 with the shared secret when there is none.
+func newAuthenticator(cfg config) (auth.Authenticator, error) {
+	if cfg.auth.token.signingKey == "" {
+		if cfg.auth.token.secret == "example" && cfg.env != "development" {
+			return nil, fmt.Errorf("AUTH_TOKEN_SECRET must be changed from its default outside development")
+		}
+
+		return auth.NewJWTAuthenticator(
+			cfg.auth.token.secret,
+			cfg.auth.token.iss,
+			cfg.auth.token.iss,
+		), nil
+	}
+
+	signingKey, err := auth.LoadSigningKey(cfg.auth.token.signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var previousKeys []crypto.PublicKey
+	for _, path := range cfg.auth.token.verificationKeys {
+		key, err := auth.LoadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, key)
+	}
+
+	return auth.NewAsymmetricJWTAuthenticator(
+		signingKey,
+		previousKeys,
+		cfg.auth.token.iss,
+		cfg.auth.token.iss,
+	)
+}
+
+// oidcProvidersConfig reads OIDC_<NAME>_* for every provider name, e.g.
+// OIDC_PROVIDERS=company is configured through OIDC_COMPANY_ISSUER and so on.
+func oidcProvidersConfig(names []string) []sso.ProviderConfig {
+	var providers []sso.ProviderConfig
+	for _, name := range names {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, sso.ProviderConfig{
+			Name:         name,
+			Issuer:       env.GetString(prefix+"ISSUER", ""),
+			ClientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  env.GetString(prefix+"REDIRECT_URL", ""),
+			Scopes:       env.GetStrings(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		})
+	}
+	return providers
+}
+
+// newRateLimiter builds the limiter for one policy, in memory when Redis is disabled.
+func newRateLimiter(cfg config, name string, rdb *redis.Client) (ratelimiter.Limiter, error) {
+	if !cfg.redisCfg.enabled {
+		// A nil *redis.Client would make a non-nil redis.Scripter
+		return ratelimiter.New(cfg.rateLimiter, name, nil)
+	}
+
+	return ratelimiter.New(cfg.rateLimiter, name, rdb)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/store"
+	"Url-Shortener/internal/totp"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	recoveryCodesCount = 10
+	maxMFAAttempts     = 5 // Wrong codes per challenge token before the user has to log in again
+	mfaSkew            = 1 // Steps of clock drift accepted either way
+)
+
+var errInvalidMFACode = errors.New("invalid two-factor code")
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// startSession issues tokens for a user whose password, or identity provider,
+// checked out. Users with two-factor authentication get a challenge instead.
+func (app *application) startSession(c echo.Context, user *store.User) error {
+	ctx := c.Request().Context()
+
+	if !user.IsActive {
+		return app.accountDeactivatedResponse(c)
+	}
+
+	if user.MFA.Enabled {
+		token, err := app.issueUserToken(ctx, user, store.TokenPurposeMFA, app.config.auth.mfa.challengeExp)
+		if err != nil {
+			return app.internalServerError(c, err)
+		}
+
+		return app.jsonResponse(c, http.StatusOK, mfaChallenge{MFARequired: true, MFAToken: token})
+	}
+
+	tokens, err := app.issueTokens(ctx, user)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusCreated, tokens)
+}
+
+// verifyMFACode accepts either a code from the authenticator app or an unused recovery code.
+func (app *application) verifyMFACode(ctx context.Context, user *store.User, code string) error {
+	if !user.MFA.Enabled {
+		return errInvalidMFACode
+	}
+
+	// Recovery codes are longer, even without their dashes
+	if len(totp.Normalize(code)) == totp.Digits {
+		step, ok := totp.Validate(user.MFA.Secret, code, time.Now(), mfaSkew)
+		if !ok {
+			return errInvalidMFACode
+		}
+		return app.store.Users.UseMFAStep(ctx, user.ID, step)
+	}
+
+	err := app.store.Users.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(code))
+	if errors.Is(err, store.ErrNotFound) {
+		return errInvalidMFACode
+	}
+	return err
+}
+
+type LoginMFAPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// loginMFAHandler is the second step of a login with two-factor authentication.
+func (app *application) loginMFAHandler(c echo.Context) error {
+	payload, err := BindAndValidate[LoginMFAPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+	hash := auth.HashToken(payload.MFAToken)
+
+	// Only used up once the code is right, so a typo doesn't cost the password step
+	challenge, err := app.store.UserTokens.Get(ctx, store.TokenPurposeMFA, hash)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.unauthorizedErrorResponse(c, errors.New("unknown or expired mfa token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	user, err := app.store.Users.GetById(ctx, challenge.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.unauthorizedErrorResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	// Codes share the lockout with passwords, a new challenge doesn't buy more guesses
+	retryAfter, err := app.loginGuard.Attempt(ctx, user.Email, c.RealIP())
+	if err != nil {
+		app.logger.Warnw("failed to check login attempts", "error", err.Error())
+	}
+	if retryAfter > 0 {
+		app.recordLoginEvent(c, user, user.Email, store.LoginLocked)
+		return app.tooManyLoginAttemptsResponse(c, retryAfter)
+	}
+
+	if err := app.verifyMFACode(ctx, user, payload.Code); err != nil {
+		switch err {
+		case errInvalidMFACode, store.ErrMFACodeReused:
+			if err := app.store.UserTokens.RecordFailure(ctx, challenge.ID, maxMFAAttempts); err != nil {
+				app.releaseLoginAttempt(c, user.Email)
+				return app.internalServerError(c, err)
+			}
+			return app.loginFailed(c, user, user.Email, err)
+		default:
+			app.releaseLoginAttempt(c, user.Email)
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if err := app.loginGuard.Succeed(ctx, user.Email, c.RealIP()); err != nil {
+		app.logger.Warnw("failed to reset login attempts", "error", err.Error())
+	}
+
+	if !user.IsActive {
+		return app.accountDeactivatedResponse(c)
+	}
+
+	if _, err := app.store.UserTokens.Consume(ctx, store.TokenPurposeMFA, hash); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			// Used by a concurrent request
+			return app.unauthorizedErrorResponse(c, errors.New("unknown or expired mfa token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.recordLoginEvent(c, user, user.Email, store.LoginSucceeded)
+
+	tokens, err := app.issueTokens(ctx, user)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusCreated, tokens)
+}
+
+type mfaEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// enrollMFAHandler starts setting up an authenticator app. Nothing changes for
+// the user until the enrollment is confirmed with a first code.
+func (app *application) enrollMFAHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Fresh from the database, the cached user doesn't carry the MFA secrets
+	user, err := app.store.Users.GetById(ctx, getUserFromContext(c).ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if user.MFA.Enabled {
+		return app.badRequestResponse(c, errors.New("two-factor authentication is already enabled"))
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.Users.SetMFA(ctx, user.ID, store.MFA{Secret: secret}); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, mfaEnrollment{
+		Secret:     secret,
+		OtpauthURI: totp.URI(secret, app.config.auth.mfa.issuer, user.Email),
+	})
+}
+
+type MFACodePayload struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type mfaRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// confirmMFAHandler turns two-factor authentication on and hands out the
+// recovery codes, they are only shown this once.
+func (app *application) confirmMFAHandler(c echo.Context) error {
+	payload, err := BindAndValidate[MFACodePayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := app.store.Users.GetById(ctx, getUserFromContext(c).ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if user.MFA.Enabled {
+		return app.badRequestResponse(c, errors.New("two-factor authentication is already enabled"))
+	}
+	if user.MFA.Secret == "" {
+		return app.badRequestResponse(c, errors.New("start the enrollment first"))
+	}
+
+	step, ok := totp.Validate(user.MFA.Secret, payload.Code, time.Now(), mfaSkew)
+	if !ok {
+		return app.badRequestResponse(c, errInvalidMFACode)
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	err = app.store.Users.SetMFA(ctx, user.ID, store.MFA{
+		Enabled:       true,
+		Secret:        user.MFA.Secret,
+		RecoveryCodes: hashes,
+		LastUsedStep:  step,
+	})
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	app.audit(c, auditEntry{Action: "user.mfa_enable", TargetType: auditTargetUser, TargetID: user.ID.Hex()})
+
+	return app.jsonResponse(c, http.StatusOK, mfaRecoveryCodes{RecoveryCodes: codes})
+}
+
+// disableMFAHandler turns two-factor authentication off, it takes a current
+// code or a recovery code so a stolen session alone isn't enough.
+func (app *application) disableMFAHandler(c echo.Context) error {
+	payload, err := BindAndValidate[MFACodePayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := app.store.Users.GetById(ctx, getUserFromContext(c).ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if !user.MFA.Enabled {
+		return app.badRequestResponse(c, errors.New("two-factor authentication isn't enabled"))
+	}
+
+	if err := app.verifyMFACode(ctx, user, payload.Code); err != nil {
+		switch err {
+		case errInvalidMFACode, store.ErrMFACodeReused:
+			return app.badRequestResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if err := app.store.Users.SetMFA(ctx, user.ID, store.MFA{}); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	app.audit(c, auditEntry{Action: "user.mfa_disable", TargetType: auditTargetUser, TargetID: user.ID.Hex()})
+
+	return c.NoContent(http.StatusNoContent)
+}
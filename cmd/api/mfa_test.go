@@ -0,0 +1,45 @@
+package main
+
+import (
+	"Url-Shortener/internal/store"
+	"Url-Shortener/internal/totp"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVerifyMFACodeAcceptsCodesWithSpaces(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &store.User{MFA: store.MFA{Enabled: true, Secret: secret}}
+
+	users := newFakeUsers(user)
+	app := &application{store: store.Storage{Users: users}}
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.verifyMFACode(context.Background(), user, code[:3]+" "+code[3:]); err != nil {
+		t.Errorf("code with a space: err = %v", err)
+	}
+	if len(users.mfaSteps) != 1 || users.mfaSteps[0] != step {
+		t.Errorf("used steps %v, want [%d]", users.mfaSteps, step)
+	}
+
+	// A wrong code with a space is a wrong code, not a recovery code
+	wrong := "000 000"
+	if _, ok := totp.Validate(secret, wrong, time.Now(), mfaSkew); ok {
+		wrong = "111 111"
+	}
+	if err := app.verifyMFACode(context.Background(), user, wrong); err != errInvalidMFACode {
+		t.Errorf("wrong code: err = %v, want errInvalidMFACode", err)
+	}
+	if users.recoveryTries != 0 {
+		t.Errorf("%d recovery code lookups, want none", users.recoveryTries)
+	}
+}
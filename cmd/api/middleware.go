@@ -1,16 +1,24 @@
 package main
 
 import (
+	"Url-Shortener/internal/auth"
 	"Url-Shortener/internal/store"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// AuthTokenMiddleware authenticates the request with either an access token
+// ("Authorization: Bearer <jwt>") or a personal API key ("Authorization: ApiKey <key>").
+// Both resolve to the same *store.User in the context.
 func (app *application) AuthTokenMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -19,39 +27,165 @@ func (app *application) AuthTokenMiddleware() echo.MiddlewareFunc {
 				return app.unauthorizedErrorResponse(c, fmt.Errorf("authorization header is missing"))
 			}
 			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
+			if len(parts) != 2 {
 				return app.unauthorizedErrorResponse(c, fmt.Errorf("authorization header is malformed"))
 			}
-			token := parts[1]
-			jwtToken, err := app.authenticator.ValidateToken(token)
-			if err != nil {
-				return app.unauthorizedErrorResponse(c, err)
-			}
-			claims, ok := jwtToken.Claims.(jwt.MapClaims)
-			if !ok {
-				return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid token claims"))
-			}
-			userIDHex, ok := claims["sub"].(string)
-			if !ok {
-				return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid subject claim"))
-			}
 
-			userID, err := primitive.ObjectIDFromHex(userIDHex)
-			if err != nil {
-				return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid ObjectID: %w", err))
+			switch parts[0] {
+			case "Bearer":
+				return app.authenticateBearer(c, parts[1], next)
+			case "ApiKey":
+				return app.authenticateAPIKey(c, parts[1], next)
+			default:
+				return app.unauthorizedErrorResponse(c, fmt.Errorf("authorization header is malformed"))
 			}
+		}
+	}
 
-			user, err := app.getUser(c.Request().Context(), userID)
-			if err != nil {
-				return app.unauthorizedErrorResponse(c, err)
-			}
+}
 
-			c.Set("user", user)
+func (app *application) authenticateBearer(c echo.Context, token string, next echo.HandlerFunc) error {
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return app.unauthorizedErrorResponse(c, err)
+	}
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid token claims"))
+	}
+	userIDHex, ok := claims["sub"].(string)
+	if !ok {
+		return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid subject claim"))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	if err != nil {
+		return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid ObjectID: %w", err))
+	}
+
+	ctx := c.Request().Context()
+
+	if err := app.checkTokenRevocation(ctx, userID, claims); err != nil {
+		if errors.Is(err, errTokenRevoked) {
+			return app.unauthorizedErrorResponse(c, err)
+		}
+		return app.internalServerError(c, err)
+	}
+
+	user, err := app.getUser(ctx, userID)
+	if err != nil {
+		return app.unauthorizedErrorResponse(c, err)
+	}
+	if !user.IsActive {
+		return app.unauthorizedErrorResponse(c, errAccountDeactivated)
+	}
+
+	scope, _ := claims["scope"].(string)
+
+	c.Set("user", user)
+	c.Set("claims", claims)
+	c.Set("scopes", auth.ParseScopes(scope))
+
+	return next(c)
+}
+
+func (app *application) authenticateAPIKey(c echo.Context, key string, next echo.HandlerFunc) error {
+	prefix, err := auth.ParseAPIKey(key)
+	if err != nil {
+		return app.unauthorizedErrorResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	apiKey, err := app.store.APIKeys.GetByPrefix(ctx, prefix)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.unauthorizedErrorResponse(c, fmt.Errorf("unknown api key"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(auth.HashToken(key))) != 1 {
+		return app.unauthorizedErrorResponse(c, fmt.Errorf("invalid api key"))
+	}
+
+	user, err := app.getUser(ctx, apiKey.UserID)
+	if err != nil {
+		return app.unauthorizedErrorResponse(c, err)
+	}
+	if !user.IsActive {
+		return app.unauthorizedErrorResponse(c, errAccountDeactivated)
+	}
+
+	if err := app.store.APIKeys.Touch(ctx, apiKey.ID, time.Now()); err != nil {
+		app.logger.Warnw("failed to record api key usage", "prefix", apiKey.Prefix, "error", err.Error())
+	}
+
+	scopes := apiKey.Scopes
+	if scopes == nil {
+		// Keys created before scopes existed
+		scopes = auth.APIKeyScopes
+	}
+
+	c.Set("user", user)
+	c.Set("apiKey", apiKey)
+	c.Set("scopes", scopes)
+
+	return next(c)
+}
+
+// requireScopes only lets through requests whose token or API key was granted
+// every one of scopes. It must run after AuthTokenMiddleware.
+func (app *application) requireScopes(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			granted, _ := c.Get("scopes").([]string)
+			if !auth.HasScopes(granted, scopes...) {
+				return app.forbiddenResponse(c)
+			}
 
 			return next(c)
 		}
 	}
+}
+
+var (
+	errTokenRevoked       = errors.New("token has been revoked")
+	errAccountDeactivated = errors.New("account is deactivated")
+)
+
+// checkTokenRevocation consults the denylist for the token itself and for a
+// "log out everywhere" issued after it.
+func (app *application) checkTokenRevocation(ctx context.Context, userID primitive.ObjectID, claims jwt.MapClaims) error {
+	denylist := app.denylist()
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return fmt.Errorf("%w: missing jti claim", errTokenRevoked)
+	}
 
+	revoked, err := denylist.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errTokenRevoked
+	}
+
+	before, err := denylist.UserTokensRevokedBefore(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !before.IsZero() {
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil || iat.Before(before) {
+			return errTokenRevoked
+		}
+	}
+
+	return nil
 }
 
 func (app *application) getUser(ctx context.Context, userID primitive.ObjectID) (*store.User, error) {
@@ -80,41 +214,143 @@ func (app *application) getUser(ctx context.Context, userID primitive.ObjectID)
 	return user, nil
 }
 
-func (app *application) RateLimiterMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		if app.config.rateLimiter.Enabled {
-			remoteIP := c.RealIP()
-			if allow, retryAfter := app.rateLimiter.Allow(remoteIP); !allow {
-				return app.rateLimitExceededResponse(c, retryAfter.String())
-			}
-		}
+// Rate limiting policies, see ratelimiter.Config.Policies
+const (
+	policyDefault  = "default"
+	policyAuth     = "auth"
+	policyRedirect = "redirect"
+	policyUser     = "user"
+)
+
+// rateLimitKeyFunc picks who a request counts against.
+type rateLimitKeyFunc func(c echo.Context) string
+
+func rateLimitByIP(c echo.Context) string {
+	return "ip:" + c.RealIP()
+}
 
-		return next(c)
+// rateLimitByUser must run after AuthTokenMiddleware, it falls back to the IP otherwise.
+func rateLimitByUser(c echo.Context) string {
+	if user := getUserFromContext(c); user != nil {
+		return "user:" + user.ID.Hex()
 	}
+	return rateLimitByIP(c)
 }
 
-func (app *application) checkUrlOwnership(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		user := getUserFromContext(c)
-		shortCode := c.Param("shortCode")
+func (app *application) RateLimiterMiddleware(policy string, key rateLimitKeyFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !app.config.rateLimiter.Enabled {
+				return next(c)
+			}
 
-		if shortCode == "" {
-			return echo.NewHTTPError(http.StatusBadRequest, "Short code is required.")
-		}
+			quota := app.rateLimiters[policy].Allow(key(c))
 
-		ctx := c.Request().Context()
+			header := c.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(quota.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(quota.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(quota.ResetAfter)))
 
-		shortURL, err := app.store.Urls.GetByShortCode(ctx, shortCode)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusNotFound, "Short URL not found")
-		}
+			if !quota.Allowed {
+				return app.rateLimitExceededResponse(c, quota.RetryAfter)
+			}
 
-		if shortURL.UserID != user.ID {
-			return echo.NewHTTPError(http.StatusForbidden, "Not authorized to delete this URL")
+			return next(c)
 		}
+	}
+}
+
+// ceilSeconds rounds up so clients never retry too early.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// checkUrlOwnership loads the link named by the ":shortCode" path parameter and
+// only lets through its owner. Links owned by a workspace are open to its
+// members with at least minRole.
+func (app *application) checkUrlOwnership(minRole string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := getUserFromContext(c)
+			shortCode := c.Param("shortCode")
 
-		c.Set("shortURL", shortURL)
-		return next(c)
+			if shortCode == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, "Short code is required.")
+			}
+
+			ctx := c.Request().Context()
+
+			shortURL, err := app.store.Urls.GetByShortCode(ctx, shortCode)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusNotFound, "Short URL not found")
+			}
+
+			if shortURL.WorkspaceID == nil {
+				if shortURL.UserID != user.ID {
+					return echo.NewHTTPError(http.StatusForbidden, "Not authorized to modify this URL")
+				}
+			} else {
+				membership, err := app.store.Memberships.Get(ctx, *shortURL.WorkspaceID, user.ID)
+				switch {
+				case errors.Is(err, store.ErrNotFound):
+					return echo.NewHTTPError(http.StatusForbidden, "Not authorized to modify this URL")
+				case err != nil:
+					return app.internalServerError(c, err)
+				}
+
+				if !store.WorkspaceRoleAtLeast(membership.Role, minRole) {
+					return echo.NewHTTPError(http.StatusForbidden, "Not authorized to modify this URL")
+				}
+			}
+
+			c.Set("shortURL", shortURL)
+			return next(c)
+		}
 	}
+}
+
+// requireWorkspaceRole loads the workspace named by the ":id" path parameter and
+// only lets through its members with at least minRole. Others get a 404, so
+// workspace IDs can't be probed.
+func (app *application) requireWorkspaceRole(minRole string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := getUserFromContext(c)
 
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+			if err != nil {
+				return app.notFoundResponse(c, store.ErrNotFound)
+			}
+
+			ctx := c.Request().Context()
+
+			membership, err := app.store.Memberships.Get(ctx, id, user.ID)
+			if err != nil {
+				switch err {
+				case store.ErrNotFound:
+					return app.notFoundResponse(c, err)
+				default:
+					return app.internalServerError(c, err)
+				}
+			}
+
+			if !store.WorkspaceRoleAtLeast(membership.Role, minRole) {
+				return app.forbiddenResponse(c)
+			}
+
+			workspace, err := app.store.Workspaces.GetByID(ctx, id)
+			if err != nil {
+				switch err {
+				case store.ErrNotFound:
+					return app.notFoundResponse(c, err)
+				default:
+					return app.internalServerError(c, err)
+				}
+			}
+
+			c.Set("workspace", workspace)
+			c.Set("membership", membership)
+			return next(c)
+		}
+	}
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/sso"
+	"Url-Shortener/internal/store"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+// oidcStateExp is how long a user has to sign in at the provider.
+const oidcStateExp = 10 * time.Minute
+
+// oidcStateCookie ties the flow to the browser that started it. Without it anyone
+// could sign in themselves and hand their callback URL to someone else, who would
+// end up in their account. It holds the hash of the state, never the state.
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/v1/auth/oidc"
+)
+
+func setOIDCStateCookie(c echo.Context, value string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcStateCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's top-level redirect back
+	})
+}
+
+// checkOIDCStateCookie reports whether the callback comes from the browser the state was issued to.
+func checkOIDCStateCookie(c echo.Context, state string) bool {
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(auth.HashToken(state))) == 1
+}
+
+func (app *application) getOIDCProvider(c echo.Context) (*sso.Provider, error) {
+	provider, ok := app.oidcProviders[c.Param("provider")]
+	if !ok {
+		return nil, fmt.Errorf("unknown identity provider %q", c.Param("provider"))
+	}
+	return provider, nil
+}
+
+// oidcLoginHandler sends the user to the provider to sign in.
+func (app *application) oidcLoginHandler(c echo.Context) error {
+	provider, err := app.getOIDCProvider(c)
+	if err != nil {
+		return app.notFoundResponse(c, err)
+	}
+
+	state, err := auth.NewTokenID()
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+	nonce, err := auth.NewTokenID()
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	stored := &store.OIDCState{
+		State:     state,
+		Provider:  provider.Name(),
+		Nonce:     nonce,
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(oidcStateExp),
+	}
+
+	ctx := c.Request().Context()
+
+	redirectURL, err := provider.AuthCodeURL(ctx, stored.State, stored.Nonce, stored.Verifier)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.OIDCStates.Create(ctx, stored); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	setOIDCStateCookie(c, auth.HashToken(stored.State), int(oidcStateExp.Seconds()))
+
+	return c.Redirect(http.StatusFound, redirectURL)
+}
+
+// oidcCallbackHandler finishes the sign in and starts a session like a password login would,
+// including the two-factor step.
+func (app *application) oidcCallbackHandler(c echo.Context) error {
+	provider, err := app.getOIDCProvider(c)
+	if err != nil {
+		return app.notFoundResponse(c, err)
+	}
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return app.unauthorizedErrorResponse(c, fmt.Errorf("identity provider: %s: %s", providerErr, c.QueryParam("error_description")))
+	}
+
+	code, state := c.QueryParam("code"), c.QueryParam("state")
+	if code == "" || state == "" {
+		return app.badRequestResponse(c, errors.New("code and state are required"))
+	}
+
+	if !checkOIDCStateCookie(c, state) {
+		return app.unauthorizedErrorResponse(c, errors.New("sign in wasn't started from this browser"))
+	}
+	setOIDCStateCookie(c, "", -1)
+
+	ctx := c.Request().Context()
+
+	stored, err := app.store.OIDCStates.Consume(ctx, provider.Name(), state)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.unauthorizedErrorResponse(c, errors.New("unknown or expired state"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	identity, err := provider.Exchange(ctx, code, stored.Nonce, stored.Verifier)
+	if err != nil {
+		return app.unauthorizedErrorResponse(c, err)
+	}
+
+	user, err := app.userForIdentity(c, provider.Name(), identity)
+	if err != nil {
+		switch err {
+		case errEmailNotVerified, errAccountNotVerified:
+			return app.unauthorizedErrorResponse(c, err)
+		}
+		return app.internalServerError(c, err)
+	}
+
+	return app.startSession(c, user)
+}
+
+var (
+	errEmailNotVerified   = errors.New("identity provider hasn't verified the email address")
+	errAccountNotVerified = errors.New("an account with this email exists but its address was never verified")
+)
+
+// userForIdentity finds the user an external identity belongs to. Unknown identities
+// are linked to the user with the same email, or to a new user when there is none.
+func (app *application) userForIdentity(c echo.Context, provider string, identity *sso.Identity) (*store.User, error) {
+	ctx := c.Request().Context()
+
+	linked, err := app.store.Identities.Get(ctx, provider, identity.Subject)
+	switch err {
+	case nil:
+		return app.store.Users.GetById(ctx, linked.UserID)
+	case store.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	// Linking by email is only safe when the provider vouches for it
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	var user *store.User
+	for attempt := 0; user == nil; attempt++ {
+		user, err = app.store.Users.GetByEmail(ctx, identity.Email)
+		switch err {
+		case nil:
+			// Whoever registered the address locally never proved they own it,
+			// linking would hand the account to them
+			if !user.EmailVerified {
+				return nil, errAccountNotVerified
+			}
+		case store.ErrNotFound:
+			user, err = app.createOIDCUser(c, identity)
+			if err == store.ErrDuplicateEmail && attempt == 0 {
+				// Registered in the meantime, the account gets the same checks
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	err = app.store.Identities.Create(ctx, &store.Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	switch err {
+	case nil:
+		app.audit(c, auditEntry{Action: "user.identity_link", TargetType: auditTargetUser, TargetID: user.ID.Hex(), After: map[string]string{"provider": provider, "subject": identity.Subject}, Actor: user})
+		return user, nil
+	case store.ErrDuplicateIdentity:
+		// A concurrent callback linked it first
+		linked, err := app.store.Identities.Get(ctx, provider, identity.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return app.store.Users.GetById(ctx, linked.UserID)
+	default:
+		return nil, err
+	}
+}
+
+// createOIDCUser creates a user without a password, it can only sign in through the provider.
+// It returns store.ErrDuplicateEmail when the address was registered in the meantime.
+func (app *application) createOIDCUser(c echo.Context, identity *sso.Identity) (*store.User, error) {
+	ctx := c.Request().Context()
+
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	username := base
+	for attempt := 0; ; attempt++ {
+		user := &store.User{
+			Username:      username,
+			Email:         identity.Email,
+			EmailVerified: true, // Checked by the provider
+		}
+
+		err := app.store.Users.Create(ctx, user)
+		switch err {
+		case nil:
+			app.audit(c, auditEntry{Action: "user.register", TargetType: auditTargetUser, TargetID: user.ID.Hex(), After: user, Actor: user})
+			return user, nil
+		case store.ErrDuplicateUsername:
+			if attempt >= 3 {
+				return nil, err
+			}
+			suffix, err := auth.NewTokenID()
+			if err != nil {
+				return nil, err
+			}
+			username = base + "-" + suffix[:6]
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/sso"
+	"Url-Shortener/internal/sso/ssotest"
+	"Url-Shortener/internal/store"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type oidcTest struct {
+	app        *application
+	issuer     *ssotest.Issuer
+	users      *fakeUsers
+	states     *fakeOIDCStates
+	identities *fakeIdentities
+	audit      *fakeAuditEvents
+}
+
+func newOIDCTest(t *testing.T, users ...*store.User) *oidcTest {
+	t.Helper()
+
+	issuer, err := ssotest.NewIssuer("shortener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	ot := &oidcTest{
+		issuer:     issuer,
+		users:      newFakeUsers(users...),
+		states:     newFakeOIDCStates(),
+		identities: &fakeIdentities{},
+		audit:      &fakeAuditEvents{},
+	}
+
+	var cfg config
+	cfg.auth.token.exp = 15 * time.Minute
+	cfg.auth.token.iss = "test"
+	cfg.auth.refresh.exp = time.Hour
+	cfg.audit.retention = time.Hour
+
+	ot.app = &application{
+		config: cfg,
+		store: store.Storage{
+			Users:         ot.users,
+			OIDCStates:    ot.states,
+			Identities:    ot.identities,
+			AuditEvents:   ot.audit,
+			RefreshTokens: &fakeRefreshTokens{},
+		},
+		logger:        zap.NewNop().Sugar(),
+		authenticator: auth.NewJWTAuthenticator("test-secret", "test", "test"),
+		oidcProviders: map[string]*sso.Provider{
+			"test": sso.NewProvider(sso.ProviderConfig{
+				Name:        "test",
+				Issuer:      issuer.URL,
+				ClientID:    "shortener",
+				RedirectURL: "http://localhost/api/v1/auth/oidc/test/callback",
+			}),
+		},
+	}
+
+	return ot
+}
+
+func (ot *oidcTest) serve(t *testing.T, handler echo.HandlerFunc, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("provider")
+	c.SetParamValues("test")
+
+	if err := handler(c); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec
+}
+
+// start begins a sign in and returns where the browser is sent and the cookie it gets.
+func (ot *oidcTest) start(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+
+	rec := ot.serve(t, ot.app.oidcLoginHandler, "/api/v1/auth/oidc/test")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("login didn't set the state cookie")
+	}
+	if !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("state cookie isn't HttpOnly and SameSite=Lax: %+v", stateCookie)
+	}
+
+	return rec.Header().Get(echo.HeaderLocation), stateCookie
+}
+
+// signIn runs the whole flow as login and returns the callback response.
+func (ot *oidcTest) signIn(t *testing.T, login ssotest.Login) *httptest.ResponseRecorder {
+	t.Helper()
+
+	authURL, cookie := ot.start(t)
+
+	code, state, err := ot.issuer.Authorize(authURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ot.callback(t, code, state, cookie)
+}
+
+func (ot *oidcTest) callback(t *testing.T, code, state string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	q := url.Values{"code": {code}, "state": {state}}
+	return ot.serve(t, ot.app.oidcCallbackHandler, "/api/v1/auth/oidc/test/callback?"+q.Encode(), cookies...)
+}
+
+// sessionUser returns the user the access token in a successful callback response was issued to.
+func (ot *oidcTest) sessionUser(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("callback status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	var res struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ot.app.authenticator.ValidateToken(res.Data.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := token.Claims.GetSubject()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sub
+}
+
+func TestOIDCFirstLoginCreatesUser(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true, Username: "ada"})
+	userID := ot.sessionUser(t, rec)
+
+	user, err := ot.users.GetByEmail(t.Context(), "ada@example.com")
+	if err != nil {
+		t.Fatal("user not created:", err)
+	}
+	if user.ID.Hex() != userID {
+		t.Errorf("session issued to %s, want the new user %s", userID, user.ID.Hex())
+	}
+	if user.Username != "ada" || !user.EmailVerified || user.Password.Hash != nil {
+		t.Errorf("user = %+v, want ada with a verified email and no password", user)
+	}
+
+	identities := ot.identities.all()
+	if len(identities) != 1 || identities[0].UserID != user.ID || identities[0].Subject != "sub-1" {
+		t.Errorf("identities = %+v, want sub-1 linked to the new user", identities)
+	}
+
+	if actions := ot.audit.actions(); !slices.Equal(actions, []string{"user.register", "user.identity_link"}) {
+		t.Errorf("audit actions = %v", actions)
+	}
+	if ot.states.count() != 0 {
+		t.Error("state not consumed")
+	}
+
+	// Signing in again uses the link
+	rec = ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true})
+	if got := ot.sessionUser(t, rec); got != userID {
+		t.Errorf("second sign in issued to %s, want %s", got, userID)
+	}
+	if ot.users.count() != 1 || len(ot.identities.all()) != 1 {
+		t.Error("second sign in created another user or link")
+	}
+}
+
+func TestOIDCLinksUserWithVerifiedEmail(t *testing.T) {
+	existing := &store.User{Username: "ada", Email: "ada@example.com", EmailVerified: true, IsActive: true, Role: store.RoleUser}
+	ot := newOIDCTest(t, existing)
+
+	rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ADA@example.com", EmailVerified: "true"})
+	if got := ot.sessionUser(t, rec); got != existing.ID.Hex() {
+		t.Errorf("session issued to %s, want the existing user %s", got, existing.ID.Hex())
+	}
+
+	if ot.users.count() != 1 {
+		t.Error("a new user was created instead of linking")
+	}
+	identities := ot.identities.all()
+	if len(identities) != 1 || identities[0].UserID != existing.ID {
+		t.Errorf("identities = %+v, want one linked to the existing user", identities)
+	}
+}
+
+func TestOIDCMatchesEmailsWhateverTheirCase(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "Ada@Example.com", EmailVerified: true, Username: "ada"})
+	userID := ot.sessionUser(t, rec)
+
+	user, err := ot.users.GetByEmail(t.Context(), "ada@example.com")
+	if err != nil {
+		t.Fatal("user not stored under the lower case address:", err)
+	}
+	if user.Email != "ada@example.com" {
+		t.Errorf("email = %q, want it in lower case", user.Email)
+	}
+
+	// Another identity sending the address in another case is linked to the same user
+	rec = ot.signIn(t, ssotest.Login{Subject: "sub-2", Email: "ADA@EXAMPLE.COM", EmailVerified: true})
+	if got := ot.sessionUser(t, rec); got != userID {
+		t.Errorf("second identity signed in as %s, want %s", got, userID)
+	}
+	if ot.users.count() != 1 {
+		t.Errorf("%d users, want the mixed case address to be the same user", ot.users.count())
+	}
+}
+
+func TestOIDCRejectsUnverifiedEmails(t *testing.T) {
+	t.Run("at the provider", func(t *testing.T) {
+		existing := &store.User{Username: "ada", Email: "ada@example.com", EmailVerified: true, IsActive: true}
+		ot := newOIDCTest(t, existing)
+
+		rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: false})
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if len(ot.identities.all()) != 0 || ot.users.count() != 1 {
+			t.Error("unverified identity was linked or created a user")
+		}
+	})
+
+	t.Run("of the local account", func(t *testing.T) {
+		existing := &store.User{Username: "ada", Email: "ada@example.com", IsActive: true}
+		ot := newOIDCTest(t, existing)
+
+		rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true})
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if len(ot.identities.all()) != 0 {
+			t.Error("identity linked to an account whose email was never verified")
+		}
+	})
+}
+
+func TestOIDCRejectsUnverifiedAccountRegisteredDuringSignIn(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	// Registered locally between the lookup by email and the creation of the user
+	attacker := &store.User{Username: "mallory", Email: "ada@example.com", IsActive: true, Role: store.RoleUser}
+	ot.users.beforeCreate = func() { ot.users.add(attacker) }
+
+	rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusUnauthorized, rec.Body)
+	}
+	if len(ot.identities.all()) != 0 {
+		t.Error("identity linked to the unverified account registered during the sign in")
+	}
+	if ot.users.count() != 1 {
+		t.Errorf("%d users, want only the attacker's", ot.users.count())
+	}
+}
+
+func TestOIDCLinksVerifiedAccountRegisteredDuringSignIn(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	existing := &store.User{Username: "ada", Email: "ada@example.com", EmailVerified: true, IsActive: true, Role: store.RoleUser}
+	ot.users.beforeCreate = func() { ot.users.add(existing) }
+
+	rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true})
+	if got := ot.sessionUser(t, rec); got != existing.ID.Hex() {
+		t.Errorf("session issued to %s, want the account registered in the meantime %s", got, existing.ID.Hex())
+	}
+}
+
+func TestOIDCRejectsBadTokens(t *testing.T) {
+	for name, login := range map[string]ssotest.Login{
+		"nonce":     {Subject: "sub-1", Email: "ada@example.com", EmailVerified: true, Nonce: "replayed"},
+		"signature": {Subject: "sub-1", Email: "ada@example.com", EmailVerified: true, BadSignature: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ot := newOIDCTest(t)
+
+			rec := ot.signIn(t, login)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			if ot.users.count() != 0 || len(ot.identities.all()) != 0 {
+				t.Error("user or link created from a rejected token")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackNeedsTheStartingBrowser(t *testing.T) {
+	ot := newOIDCTest(t)
+	login := ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true}
+
+	// The attacker starts the flow and signs in, the victim follows the callback link
+	authURL, _ := ot.start(t)
+	code, state, err := ot.issuer.Authorize(authURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, victimCookie := ot.start(t)
+
+	for name, cookies := range map[string][]*http.Cookie{
+		"no cookie":           nil,
+		"other flow's cookie": {victimCookie},
+	} {
+		rec := ot.callback(t, code, state, cookies...)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	if ot.users.count() != 0 {
+		t.Error("callback without the matching cookie signed in")
+	}
+}
+
+func TestOIDCStateCanOnlyBeUsedOnce(t *testing.T) {
+	ot := newOIDCTest(t)
+	login := ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true}
+
+	authURL, cookie := ot.start(t)
+	code, state, err := ot.issuer.Authorize(authURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ot.sessionUser(t, ot.callback(t, code, state, cookie))
+
+	if rec := ot.callback(t, code, state, cookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed callback status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
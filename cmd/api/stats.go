@@ -0,0 +1,100 @@
+package main
+
+import (
+	"Url-Shortener/internal/store"
+	"Url-Shortener/internal/useragent"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const statsBreakdownSize = 20
+
+// Default and maximum look-back for each interval, so a single query can't ask for millions of buckets.
+var statsRanges = map[string]struct{ def, max time.Duration }{
+	"hour": {def: 48 * time.Hour, max: 31 * 24 * time.Hour},
+	"day":  {def: 30 * 24 * time.Hour, max: 366 * 24 * time.Hour},
+	"week": {def: 26 * 7 * 24 * time.Hour, max: 5 * 365 * 24 * time.Hour},
+}
+
+// newClick captures the request metadata we keep for analytics.
+func (app *application) newClick(c echo.Context, shortURL *store.ShortURL, now time.Time) store.Click {
+	req := c.Request()
+	ua := useragent.Parse(req.UserAgent())
+
+	return store.Click{
+		URLID:      shortURL.ID,
+		ShortCode:  shortURL.ShortCode,
+		OccurredAt: now,
+		Referrer:   referrerHost(req.Referer()),
+		Browser:    ua.Browser,
+		OS:         ua.OS,
+		Device:     ua.Device,
+		Country:    app.geoip.Country(net.ParseIP(c.RealIP())),
+	}
+}
+
+func referrerHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Hostname() == "" {
+		return "direct"
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+type ClickStatsQuery struct {
+	Interval string     `query:"interval" validate:"omitempty,oneof=hour day week"`
+	From     *time.Time `query:"from"`
+	To       *time.Time `query:"to"`
+}
+
+func (app *application) getUrlStatsHandler(c echo.Context) error {
+	query, err := BindAndValidate[ClickStatsQuery](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	if query.Interval == "" {
+		query.Interval = "day"
+	}
+	limits := statsRanges[query.Interval]
+
+	to := time.Now().UTC()
+	if query.To != nil {
+		to = query.To.UTC()
+	}
+	from := to.Add(-limits.def)
+	if query.From != nil {
+		from = query.From.UTC()
+	}
+
+	if !from.Before(to) {
+		return app.badRequestResponse(c, errors.New("from must be before to"))
+	}
+	if to.Sub(from) > limits.max {
+		return app.badRequestResponse(c, fmt.Errorf("range can't exceed %s for interval %q", limits.max, query.Interval))
+	}
+
+	shortURL := c.Get("shortURL").(*store.ShortURL) // Get from context set by middleware
+	ctx := c.Request().Context()
+
+	stats, err := app.store.Clicks.Stats(ctx, shortURL.ID, store.StatsQuery{
+		From:     from,
+		To:       to,
+		Interval: query.Interval,
+		Top:      statsBreakdownSize,
+	})
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, stats)
+}
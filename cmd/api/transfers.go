@@ -0,0 +1,394 @@
+package main
+
+import (
+	"Url-Shortener/internal/store"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errTransferRecipient = errors.New("set either to_email or to_workspace_id")
+	errRecipientNotFound = errors.New("recipient not found")
+	errSameOwner         = errors.New("the link already belongs to the recipient")
+	errTransferResolved  = errors.New("the transfer is no longer pending")
+	errTransferOutdated  = errors.New("the link changed owner since the transfer was requested")
+)
+
+type TransferPayload struct {
+	ToEmail       string `json:"to_email" validate:"omitempty,email,max=255"`
+	ToWorkspaceID string `json:"to_workspace_id" validate:"omitempty,mongodb"`
+}
+
+// transferRecipient resolves who the payload names as the new owner of shortURL.
+// Links moving into a workspace keep their creator.
+func (app *application) transferRecipient(ctx context.Context, payload *TransferPayload, shortURL *store.ShortURL) (store.LinkOwner, error) {
+	var to store.LinkOwner
+
+	switch {
+	case (payload.ToEmail == "") == (payload.ToWorkspaceID == ""):
+		return to, errTransferRecipient
+
+	case payload.ToEmail != "":
+		user, err := app.store.Users.GetByEmail(ctx, payload.ToEmail)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return to, errRecipientNotFound
+			}
+			return to, err
+		}
+		if !user.IsActive {
+			return to, errRecipientNotFound
+		}
+		to.UserID = user.ID
+
+	default:
+		id, _ := primitive.ObjectIDFromHex(payload.ToWorkspaceID)
+		if _, err := app.store.Workspaces.GetByID(ctx, id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return to, errRecipientNotFound
+			}
+			return to, err
+		}
+		to.UserID = shortURL.UserID
+		to.WorkspaceID = &id
+	}
+
+	if to.Equal(shortURL.Owner()) {
+		return to, errSameOwner
+	}
+
+	return to, nil
+}
+
+// createTransferHandler offers a link to another user or workspace. Nothing
+// moves until the recipient accepts.
+func (app *application) createTransferHandler(c echo.Context) error {
+	payload, err := BindAndValidate[TransferPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+	shortURL := c.Get("shortURL").(*store.ShortURL) // Get from context set by middleware
+
+	to, err := app.transferRecipient(ctx, payload, shortURL)
+	if err != nil {
+		switch err {
+		case errTransferRecipient, errSameOwner:
+			return app.badRequestResponse(c, err)
+		case errRecipientNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	user := getUserFromContext(c)
+
+	transfer := &store.Transfer{
+		ShortURLID:  shortURL.ID,
+		ShortCode:   shortURL.ShortCode,
+		From:        shortURL.Owner(),
+		To:          to,
+		Status:      store.TransferPending,
+		RequestedBy: &user.ID,
+	}
+
+	if err := app.store.Transfers.Create(ctx, transfer); err != nil {
+		switch err {
+		case store.ErrTransferPending:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.auditTransfer(c, "transfer.request", nil, transfer)
+
+	return app.jsonResponse(c, http.StatusCreated, transfer)
+}
+
+// getUrlTransfersHandler returns the ownership history of a link.
+func (app *application) getUrlTransfersHandler(c echo.Context) error {
+	shortURL := c.Get("shortURL").(*store.ShortURL) // Get from context set by middleware
+
+	transfers, err := app.store.Transfers.ListByURL(c.Request().Context(), shortURL.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, transfers)
+}
+
+// getTransfersHandler lists the transfers waiting for the caller to accept or reject,
+// those addressed to them and to the workspaces they administer.
+func (app *application) getTransfersHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+
+	memberships, err := app.store.Memberships.ListByUser(ctx, user.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	var workspaceIDs []primitive.ObjectID
+	for _, membership := range memberships {
+		if store.WorkspaceRoleAtLeast(membership.Role, store.WorkspaceRoleAdmin) {
+			workspaceIDs = append(workspaceIDs, membership.WorkspaceID)
+		}
+	}
+
+	transfers, err := app.store.Transfers.ListPendingFor(ctx, user.ID, workspaceIDs)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, transfers)
+}
+
+// isWorkspaceAdmin reports whether the user administers the workspace.
+func (app *application) isWorkspaceAdmin(ctx context.Context, workspaceID, userID primitive.ObjectID) (bool, error) {
+	membership, err := app.store.Memberships.Get(ctx, workspaceID, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return store.WorkspaceRoleAtLeast(membership.Role, store.WorkspaceRoleAdmin), nil
+}
+
+// isLinkOwner reports whether the user speaks for owner: is them, or administers their workspace.
+func (app *application) isLinkOwner(ctx context.Context, owner store.LinkOwner, userID primitive.ObjectID) (bool, error) {
+	if owner.WorkspaceID == nil {
+		return owner.UserID == userID, nil
+	}
+
+	return app.isWorkspaceAdmin(ctx, *owner.WorkspaceID, userID)
+}
+
+// getTransferByParam loads the transfer named by the ":id" path parameter, if
+// the caller may act on it as the recipient (or, with sender, as the sender).
+// Other transfers are reported as not found.
+func (app *application) getTransferByParam(c echo.Context, sender bool) (*store.Transfer, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+
+	transfer, err := app.store.Transfers.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed bool
+	switch {
+	case sender && transfer.RequestedBy != nil && *transfer.RequestedBy == user.ID:
+		allowed = true
+	case sender:
+		allowed, err = app.isLinkOwner(ctx, transfer.From, user.ID)
+	default:
+		allowed, err = app.isLinkOwner(ctx, transfer.To, user.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, store.ErrNotFound
+	}
+
+	return transfer, nil
+}
+
+// acceptTransferHandler moves the link to the recipient. The transfer is claimed
+// first, so it can only be accepted once, and the link only moves if it still
+// belongs to whoever offered it.
+func (app *application) acceptTransferHandler(c echo.Context) error {
+	transfer, err := app.getTransferByParam(c, false)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+	pending := transfer
+
+	transfer, err = app.store.Transfers.Resolve(ctx, transfer.ID, store.TransferPending, store.TransferAccepted, &user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.conflictResponse(c, errTransferResolved)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if _, err := app.store.Urls.TransferOwner(ctx, transfer.ShortURLID, transfer.From, transfer.To); err != nil {
+		// Either way the link didn't move, the transfer mustn't say it did
+		if _, resolveErr := app.store.Transfers.Resolve(ctx, transfer.ID, store.TransferAccepted, store.TransferCancelled, &user.ID); resolveErr != nil {
+			app.logger.Errorw("failed to cancel transfer", "transfer", transfer.ID.Hex(), "error", resolveErr.Error())
+		}
+
+		switch err {
+		case store.ErrEditConflict:
+			return app.conflictResponse(c, errTransferOutdated)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.invalidateShortURL(ctx, transfer.ShortCode)
+
+	app.auditTransfer(c, "transfer.accept", pending, transfer)
+
+	return app.jsonResponse(c, http.StatusOK, transfer)
+}
+
+func (app *application) rejectTransferHandler(c echo.Context) error {
+	return app.resolveTransfer(c, false, store.TransferRejected, "transfer.reject")
+}
+
+// cancelTransferHandler withdraws a transfer, for whoever requested it or speaks for the link's owner.
+func (app *application) cancelTransferHandler(c echo.Context) error {
+	return app.resolveTransfer(c, true, store.TransferCancelled, "transfer.cancel")
+}
+
+func (app *application) resolveTransfer(c echo.Context, sender bool, status, action string) error {
+	transfer, err := app.getTransferByParam(c, sender)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	user := getUserFromContext(c)
+	pending := transfer
+
+	transfer, err = app.store.Transfers.Resolve(c.Request().Context(), transfer.ID, store.TransferPending, status, &user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.conflictResponse(c, errTransferResolved)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.auditTransfer(c, action, pending, transfer)
+
+	return app.jsonResponse(c, http.StatusOK, transfer)
+}
+
+// forceTransferHandler moves any link right away, without the recipient
+// accepting, e.g. when its owner left. A pending transfer of the link is cancelled.
+func (app *application) forceTransferHandler(c echo.Context) error {
+	payload, err := BindAndValidate[TransferPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	shortURL, err := app.store.Urls.GetByShortCode(ctx, c.Param("shortCode"))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	to, err := app.transferRecipient(ctx, payload, shortURL)
+	if err != nil {
+		switch err {
+		case errTransferRecipient, errSameOwner:
+			return app.badRequestResponse(c, err)
+		case errRecipientNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	// Signed in with basic auth there is no user to record
+	var by *primitive.ObjectID
+	if admin := getUserFromContext(c); admin != nil {
+		by = &admin.ID
+	}
+
+	if err := app.store.Transfers.CancelPending(ctx, shortURL.ID, by); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	from := shortURL.Owner()
+
+	shortURL, err = app.store.Urls.TransferOwner(ctx, shortURL.ID, from, to)
+	if err != nil {
+		switch err {
+		case store.ErrEditConflict:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.invalidateShortURL(ctx, shortURL.ShortCode)
+
+	transfer := &store.Transfer{
+		ShortURLID:  shortURL.ID,
+		ShortCode:   shortURL.ShortCode,
+		From:        from,
+		To:          to,
+		Status:      store.TransferForced,
+		RequestedBy: by,
+		ResolvedBy:  by,
+	}
+
+	if err := app.store.Transfers.Create(ctx, transfer); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.logger.Infow("link transferred by admin", "short_code", shortURL.ShortCode, "from", from.UserID.Hex(), "to", to.UserID.Hex())
+
+	app.auditTransfer(c, "transfer.force", nil, transfer)
+
+	return app.jsonResponse(c, http.StatusOK, transfer)
+}
+
+// auditTransfer records a step of a transfer, visible to both the sending and the receiving workspace.
+func (app *application) auditTransfer(c echo.Context, action string, before, after *store.Transfer) {
+	var workspaces []primitive.ObjectID
+	for _, owner := range []store.LinkOwner{after.From, after.To} {
+		if owner.WorkspaceID != nil {
+			workspaces = append(workspaces, *owner.WorkspaceID)
+		}
+	}
+
+	app.audit(c, auditEntry{
+		Action:     action,
+		TargetType: auditTargetTransfer,
+		TargetID:   after.ID.Hex(),
+		Workspaces: workspaces,
+		Before:     before,
+		After:      after,
+	})
+}
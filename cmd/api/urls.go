@@ -2,12 +2,49 @@ package main
 
 import (
 	"Url-Shortener/internal/store"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 )
 
+var (
+	aliasPattern     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	errReservedAlias = errors.New("alias is reserved")
+)
+
+// reservedAliases can't be used as custom short codes because they clash with
+// routes (or would look like they belong to us).
+var reservedAliases = map[string]struct{}{
+	"admin":    {},
+	"api":      {},
+	"auth":     {},
+	"health":   {},
+	"login":    {},
+	"logout":   {},
+	"register": {},
+	"shorten":  {},
+	"static":   {},
+	"urls":     {},
+	"users":    {},
+	"www":      {},
+}
+
+func isReservedAlias(alias string) bool {
+	_, ok := reservedAliases[strings.ToLower(alias)]
+	return ok
+}
+
 type CreateUrlPayload struct {
-	OriginalUrl string `json:"url"`
+	OriginalUrl string `json:"url" validate:"required,url,max=2048"`
+	Alias       string `json:"alias" validate:"omitempty,min=3,max=32,alias"`
+	WorkspaceID string `json:"workspace_id" validate:"omitempty,mongodb"` // Optional, the workspace owning the link
+	ExpirationPayload
 }
 
 func (app *application) createUrlHandler(c echo.Context) error {
@@ -20,17 +57,47 @@ func (app *application) createUrlHandler(c echo.Context) error {
 
 	user := getUserFromContext(c)
 
-	url := &store.ShortURL{
-		OriginalURL: payload.OriginalUrl,
-		UserID:      user.ID,
+	url, err := app.newShortURL(payload, user, time.Now())
+	if err != nil {
+		return app.badRequestResponse(c, err)
 	}
 
 	context := c.Request().Context()
 
+	if payload.WorkspaceID != "" {
+		workspaceID, _ := primitive.ObjectIDFromHex(payload.WorkspaceID)
+
+		membership, err := app.store.Memberships.Get(context, workspaceID, user.ID)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				return app.notFoundResponse(c, errors.New("workspace not found"))
+			default:
+				return app.internalServerError(c, err)
+			}
+		}
+
+		if !store.WorkspaceRoleAtLeast(membership.Role, store.WorkspaceRoleEditor) {
+			return app.forbiddenResponse(c)
+		}
+
+		url.WorkspaceID = &workspaceID
+	}
+
 	if err := app.store.Urls.Create(context, url); err != nil {
-		return app.internalServerError(c, err)
+		switch err {
+		case store.ErrDuplicateShortCode:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
 	}
 
+	// The alias may have been looked up (and cached as missing) before it existed
+	app.invalidateShortURL(context, url.ShortCode)
+
+	app.audit(c, auditEntry{Action: "url.create", TargetType: auditTargetURL, TargetID: url.ShortCode, Workspaces: workspaceOf(url), After: url})
+
 	if err := app.jsonResponse(c, http.StatusCreated, url); err != nil {
 		return app.internalServerError(c, err)
 	}
@@ -38,6 +105,178 @@ func (app *application) createUrlHandler(c echo.Context) error {
 	return nil
 }
 
+// newShortURL checks the parts of the payload that don't need the database
+// and returns the link to create, still without a workspace.
+func (app *application) newShortURL(payload *CreateUrlPayload, user *store.User, now time.Time) (*store.ShortURL, error) {
+	if payload.Alias != "" && isReservedAlias(payload.Alias) {
+		return nil, errReservedAlias
+	}
+
+	expiresAt, err := app.resolveExpiration(payload.ExpirationPayload, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.ShortURL{
+		ShortCode:   payload.Alias,
+		OriginalURL: payload.OriginalUrl,
+		UserID:      user.ID,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+type BulkCreateUrlsPayload struct {
+	Items []CreateUrlPayload `json:"items" validate:"required,min=1"` // Validated one by one by the handler
+}
+
+// Error codes of bulk items, so clients can tell what to fix without parsing messages
+const (
+	bulkErrInvalid           = "invalid"
+	bulkErrReservedAlias     = "reserved_alias"
+	bulkErrAliasTaken        = "alias_taken"
+	bulkErrWorkspaceNotFound = "workspace_not_found"
+	bulkErrForbidden         = "forbidden"
+	bulkErrInternal          = "internal"
+)
+
+type bulkItemError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// bulkItemResult is the outcome of one item, in the order they were sent.
+type bulkItemResult struct {
+	Index int             `json:"index"`
+	Url   *store.ShortURL `json:"url,omitempty"`
+	Error *bulkItemError  `json:"error,omitempty"`
+}
+
+type bulkCreateResponse struct {
+	Created int              `json:"created"`
+	Failed  int              `json:"failed"`
+	Results []bulkItemResult `json:"results"`
+}
+
+// bulkCreateUrlsHandler creates many links in one request. Every item is checked
+// and created on its own, the ones that fail are reported next to the others.
+func (app *application) bulkCreateUrlsHandler(c echo.Context) error {
+	payload, err := BindAndValidate[BulkCreateUrlsPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	if len(payload.Items) > app.config.urls.bulkMax {
+		return app.badRequestResponse(c, fmt.Errorf("at most %d items can be created at once", app.config.urls.bulkMax))
+	}
+
+	user := getUserFromContext(c)
+	ctx := c.Request().Context()
+	now := time.Now()
+
+	results := make([]bulkItemResult, len(payload.Items))
+	fail := func(i int, code string, err error) {
+		results[i].Error = &bulkItemError{Code: code, Message: err.Error()}
+	}
+
+	// Items usually share a few workspaces, look each of them up once
+	roles := map[primitive.ObjectID]string{}
+
+	var urls []*store.ShortURL
+	var indexes []int
+
+	for i := range payload.Items {
+		item := &payload.Items[i]
+		results[i].Index = i
+
+		if err := Validate.Struct(item); err != nil {
+			fail(i, bulkErrInvalid, err)
+			continue
+		}
+
+		url, err := app.newShortURL(item, user, now)
+		if err != nil {
+			switch err {
+			case errReservedAlias:
+				fail(i, bulkErrReservedAlias, err)
+			default:
+				fail(i, bulkErrInvalid, err)
+			}
+			continue
+		}
+
+		if item.WorkspaceID != "" {
+			workspaceID, _ := primitive.ObjectIDFromHex(item.WorkspaceID)
+
+			role, ok := roles[workspaceID]
+			if !ok {
+				membership, err := app.store.Memberships.Get(ctx, workspaceID, user.ID)
+				switch err {
+				case nil:
+					role = membership.Role
+				case store.ErrNotFound:
+					// Not a member, no role
+				default:
+					return app.internalServerError(c, err)
+				}
+				roles[workspaceID] = role
+			}
+
+			if role == "" {
+				fail(i, bulkErrWorkspaceNotFound, errors.New("workspace not found"))
+				continue
+			}
+			if !store.WorkspaceRoleAtLeast(role, store.WorkspaceRoleEditor) {
+				fail(i, bulkErrForbidden, errors.New("editor role required in the workspace"))
+				continue
+			}
+
+			url.WorkspaceID = &workspaceID
+		}
+
+		urls = append(urls, url)
+		indexes = append(indexes, i)
+	}
+
+	if len(urls) > 0 {
+		for j, err := range app.store.Urls.CreateMany(ctx, urls) {
+			i := indexes[j]
+
+			switch {
+			case err == nil:
+				results[i].Url = urls[j]
+			case errors.Is(err, store.ErrDuplicateShortCode):
+				fail(i, bulkErrAliasTaken, err)
+			default:
+				app.logger.Errorw("bulk link creation failed", "index", i, "error", err.Error())
+				fail(i, bulkErrInternal, errors.New("the link could not be created"))
+			}
+		}
+	}
+
+	res := bulkCreateResponse{Results: results}
+
+	var created []string
+	var entries []auditEntry
+	for _, result := range results {
+		if result.Url == nil {
+			res.Failed++
+			continue
+		}
+		res.Created++
+
+		created = append(created, result.Url.ShortCode)
+		entries = append(entries, auditEntry{Action: "url.create", TargetType: auditTargetURL, TargetID: result.Url.ShortCode, Workspaces: workspaceOf(result.Url), After: result.Url})
+	}
+
+	// Aliases may have been looked up (and cached as missing) before they existed
+	app.invalidateShortURLs(ctx, created)
+
+	app.auditMany(c, entries)
+
+	return app.jsonResponse(c, http.StatusOK, res)
+}
+
 func (app *application) getUrlHandler(c echo.Context) error {
 	shortCode := c.Param("shortCode")
 
@@ -47,27 +286,141 @@ func (app *application) getUrlHandler(c echo.Context) error {
 
 	context := c.Request().Context()
 
-	shortenedUrl, err := app.store.Urls.GetByShortCode(context, shortCode)
+	shortenedUrl, err := app.getShortURL(context, shortCode)
 
 	if err != nil {
-		return app.internalServerError(c, err)
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if shortenedUrl.Disabled {
+		return app.notFoundResponse(c, errors.New("short url is disabled"))
+	}
+
+	now := time.Now()
+
+	if shortenedUrl.IsExpired(now) {
+		return app.goneResponse(c, errors.New("short url has expired"))
+	}
+
+	if err := app.clicks.Record(app.newClick(c, shortenedUrl, now)); err != nil {
+		app.logger.Warnw("click not recorded", "short_code", shortCode, "error", err.Error())
 	}
 
 	return c.Redirect(http.StatusFound, shortenedUrl.OriginalURL)
 }
 
+type ListUrlsQuery struct {
+	Search      string     `query:"q" validate:"max=200"`
+	Status      string     `query:"status" validate:"omitempty,oneof=active expired disabled"`
+	CreatedFrom *time.Time `query:"created_from"`
+	CreatedTo   *time.Time `query:"created_to"`
+	Domain      string     `query:"domain" validate:"max=255"`
+	Sort        string     `query:"sort" validate:"omitempty,oneof=created_at visit_count expires_at"`
+	Order       string     `query:"order" validate:"omitempty,oneof=asc desc"`
+	Cursor      string     `query:"cursor" validate:"max=512"`
+	Limit       int64      `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+const listUrlsDefaultLimit = 50
+
+type urlPageResponse struct {
+	Urls []store.ShortURL `json:"urls"`
+	Next string           `json:"next,omitempty"` // Pass as cursor for the next page, empty on the last one
+}
+
 func (app *application) getAllUrlsByUserHandler(c echo.Context) error {
-	user := getUserFromContext(c)
+	return app.listUrls(c, store.UrlPageQuery{UserID: getUserFromContext(c).ID})
+}
 
-	context := c.Request().Context()
+// listUrls answers with a page of the links selected by q and the query string.
+func (app *application) listUrls(c echo.Context, q store.UrlPageQuery) error {
+	query, err := BindAndValidate[ListUrlsQuery](c)
 
-	urls, err := app.store.Urls.GetAllUrlsByUser(context, user.ID)
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	q.Search = query.Search
+	q.Status = query.Status
+	q.CreatedFrom = query.CreatedFrom
+	q.CreatedTo = query.CreatedTo
+	q.Domain = query.Domain
+	q.Sort = query.Sort
+	q.Descending = query.Order != "asc" // Newest, most visited or latest expiring first
+	q.Cursor = query.Cursor
+	q.Limit = query.Limit
+	if q.Limit == 0 {
+		q.Limit = listUrlsDefaultLimit
+	}
 
+	urls, next, err := app.store.Urls.ListPage(c.Request().Context(), q)
 	if err != nil {
-		return app.internalServerError(c, err)
+		switch err {
+		case store.ErrInvalidCursor:
+			return app.badRequestResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	return app.jsonResponse(c, http.StatusOK, urlPageResponse{Urls: urls, Next: next})
+}
+
+type UpdateUrlPayload struct {
+	OriginalUrl *string `json:"url" validate:"omitnil,url,max=2048"`
+	Disabled    *bool   `json:"disabled"`
+	Version     *int64  `json:"version"` // Optional, the version the client last saw
+	ExpirationPayload
+}
+
+func (app *application) updateUrlHandler(c echo.Context) error {
+	payload, err := BindAndValidate[UpdateUrlPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	shortURL := c.Get("shortURL").(*store.ShortURL) // Get from context set by middleware
+	before := *shortURL
+
+	if payload.Version != nil {
+		shortURL.Version = *payload.Version
 	}
+	if payload.OriginalUrl != nil {
+		shortURL.OriginalURL = *payload.OriginalUrl
+	}
+	if payload.Disabled != nil {
+		shortURL.Disabled = *payload.Disabled
+	}
+	if payload.ExpirationPayload.isSet() {
+		expiresAt, err := app.resolveExpiration(payload.ExpirationPayload, time.Now())
+		if err != nil {
+			return app.badRequestResponse(c, err)
+		}
+		shortURL.ExpiresAt = expiresAt
+	}
+
+	ctx := c.Request().Context()
+
+	if err := app.store.Urls.Update(ctx, shortURL); err != nil {
+		switch err {
+		case store.ErrEditConflict:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.invalidateShortURL(ctx, shortURL.ShortCode)
+
+	app.audit(c, auditEntry{Action: "url.update", TargetType: auditTargetURL, TargetID: shortURL.ShortCode, Workspaces: workspaceOf(shortURL), Before: &before, After: shortURL})
 
-	return app.jsonResponse(c, http.StatusOK, urls)
+	return app.jsonResponse(c, http.StatusOK, shortURL)
 }
 
 func (app *application) deleteUrlHandler(c echo.Context) error {
@@ -78,5 +431,86 @@ func (app *application) deleteUrlHandler(c echo.Context) error {
 		return app.internalServerError(c, err)
 	}
 
+	app.invalidateShortURL(ctx, shortURL.ShortCode)
+
+	app.audit(c, auditEntry{Action: "url.delete", TargetType: auditTargetURL, TargetID: shortURL.ShortCode, Workspaces: workspaceOf(shortURL), Before: shortURL})
+
 	return c.NoContent(http.StatusNoContent)
 }
+
+// getShortURL resolves a short code for redirects through the Redis cache when it is enabled.
+// Unknown codes are cached too, and concurrent misses for one code share a single database lookup.
+func (app *application) getShortURL(ctx context.Context, shortCode string) (*store.ShortURL, error) {
+	// No Redis
+	if !app.config.redisCfg.enabled {
+		return app.store.Urls.GetByShortCode(ctx, shortCode)
+	}
+
+	shortURL, err := app.cacheStorage.Urls.Get(ctx, shortCode)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil, err
+	case err != nil:
+		// Redis trouble shouldn't take redirects down with it
+		app.logger.Warnw("url cache read failed", "short_code", shortCode, "error", err.Error())
+	case shortURL != nil:
+		return shortURL, nil
+	}
+
+	v, err, _ := app.urlLookups.Do(shortCode, func() (any, error) {
+		// Shared by every waiting request, so it must not die with the first one
+		ctx := context.WithoutCancel(ctx)
+
+		shortURL, err := app.store.Urls.GetByShortCode(ctx, shortCode)
+		if errors.Is(err, store.ErrNotFound) {
+			if cacheErr := app.cacheStorage.Urls.SetNotFound(ctx, shortCode); cacheErr != nil {
+				app.logger.Warnw("url cache write failed", "short_code", shortCode, "error", cacheErr.Error())
+			}
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if cacheErr := app.cacheStorage.Urls.Set(ctx, shortURL); cacheErr != nil {
+			app.logger.Warnw("url cache write failed", "short_code", shortCode, "error", cacheErr.Error())
+		}
+
+		return shortURL, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*store.ShortURL), nil
+}
+
+func (app *application) invalidateShortURL(ctx context.Context, shortCode string) {
+	if app.config.redisCfg.enabled {
+		app.cacheStorage.Urls.Delete(ctx, shortCode)
+	}
+}
+
+// invalidateShortURLs is invalidateShortURL for many links in one round trip.
+func (app *application) invalidateShortURLs(ctx context.Context, shortCodes []string) {
+	if app.config.redisCfg.enabled {
+		app.cacheStorage.Urls.DeleteMany(ctx, shortCodes)
+	}
+}
+
+// cleanupDeletedUrls drops what is kept about links deleted in bulk: their cache entries and clicks.
+func (app *application) cleanupDeletedUrls(ctx context.Context, urls []store.ShortURL) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(urls))
+	shortCodes := make([]string, len(urls))
+	for i, shortURL := range urls {
+		ids[i] = shortURL.ID
+		shortCodes[i] = shortURL.ShortCode
+	}
+	app.invalidateShortURLs(ctx, shortCodes)
+
+	return app.store.Clicks.DeleteByURLs(ctx, ids)
+}
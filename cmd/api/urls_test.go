@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateUrlPayloadNeedsAURL(t *testing.T) {
+	for name, payload := range map[string]CreateUrlPayload{
+		"missing":   {},
+		"not a url": {OriginalUrl: "not a url"},
+		"too long":  {OriginalUrl: "https://example.com/" + strings.Repeat("a", 2048)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := Validate.Struct(&payload); err == nil {
+				t.Error("payload accepted")
+			}
+		})
+	}
+
+	if err := Validate.Struct(&CreateUrlPayload{OriginalUrl: "https://example.com/spring", Alias: "spring"}); err != nil {
+		t.Errorf("valid payload refused: %v", err)
+	}
+}
@@ -2,10 +2,279 @@ package main
 
 import (
 	"Url-Shortener/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
 )
 
 func getUserFromContext(c echo.Context) *store.User {
 	user, _ := c.Get("user").(*store.User)
 	return user
 }
+
+// getClaimsFromContext returns the claims of the access token used for the request, if any.
+func getClaimsFromContext(c echo.Context) jwt.MapClaims {
+	claims, _ := c.Get("claims").(jwt.MapClaims)
+	return claims
+}
+
+// invalidateUser drops the cached copy of the user after it changed.
+func (app *application) invalidateUser(ctx context.Context, userID primitive.ObjectID) {
+	if app.config.redisCfg.enabled {
+		app.cacheStorage.Users.Delete(ctx, userID)
+	}
+}
+
+// getCurrentUser loads the authenticated user from the database. The one in the
+// context may come from the cache, which doesn't carry the password hash.
+func (app *application) getCurrentUser(c echo.Context) (*store.User, error) {
+	return app.store.Users.GetById(c.Request().Context(), getUserFromContext(c).ID)
+}
+
+var (
+	errWrongPassword  = errors.New("current password is wrong")
+	errReauthRequired = errors.New("sign in again to confirm this change")
+)
+
+// reauthWindow is how recent a sign in has to be to stand in for the password.
+const reauthWindow = 5 * time.Minute
+
+// checkCurrentPassword guards sensitive changes. Users who only sign in through
+// an identity provider have no password to confirm, they must have signed in recently instead.
+func checkCurrentPassword(c echo.Context, user *store.User, password string) error {
+	if len(user.Password.Hash) == 0 {
+		if !signedInSince(c, time.Now().Add(-reauthWindow)) {
+			return errReauthRequired
+		}
+		return nil
+	}
+
+	if err := user.Password.Compare(password); err != nil {
+		return errWrongPassword
+	}
+
+	return nil
+}
+
+// signedInSince reports whether the session of the request's access token
+// started after t. API keys never qualify.
+func signedInSince(c echo.Context, t time.Time) bool {
+	var authTime int64
+	switch v := getClaimsFromContext(c)["auth_time"].(type) {
+	case float64:
+		authTime = int64(v)
+	case json.Number:
+		authTime, _ = v.Int64()
+	default:
+		return false
+	}
+
+	return !time.Unix(authTime, 0).Before(t.Truncate(time.Second))
+}
+
+func (app *application) getCurrentUserHandler(c echo.Context) error {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, user)
+}
+
+type UpdateUserPayload struct {
+	Username        *string `json:"username" validate:"omitnil,min=1,max=100"`
+	Email           *string `json:"email" validate:"omitnil,email,max=255"`
+	CurrentPassword string  `json:"current_password"` // Required to change the email
+}
+
+func (app *application) updateCurrentUserHandler(c echo.Context) error {
+	payload, err := BindAndValidate[UpdateUserPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	before := *user
+
+	if payload.Username != nil {
+		user.Username = *payload.Username
+	}
+
+	emailChanged := payload.Email != nil && store.NormalizeEmail(*payload.Email) != user.Email
+	if emailChanged {
+		// Whoever controls the email controls the account through password resets
+		if err := checkCurrentPassword(c, user, payload.CurrentPassword); err != nil {
+			return app.badRequestResponse(c, err)
+		}
+
+		user.Email = store.NormalizeEmail(*payload.Email)
+		user.EmailVerified = false
+	}
+
+	if err := app.store.Users.Update(ctx, user); err != nil {
+		switch err {
+		case store.ErrDuplicateEmail, store.ErrDuplicateUsername:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	app.audit(c, auditEntry{Action: "user.update", TargetType: auditTargetUser, TargetID: user.ID.Hex(), Before: &before, After: user})
+
+	if emailChanged {
+		if err := app.sendVerificationEmail(ctx, user); err != nil {
+			app.logger.Errorw("failed to send verification email", "user", user.ID.Hex(), "error", err.Error())
+		}
+	}
+
+	return app.jsonResponse(c, http.StatusOK, user)
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" validate:"required,min=3,max=72"`
+}
+
+// changePasswordHandler sets a new password and ends every other session,
+// the caller gets a fresh pair of tokens.
+func (app *application) changePasswordHandler(c echo.Context) error {
+	payload, err := BindAndValidate[ChangePasswordPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := checkCurrentPassword(c, user, payload.CurrentPassword); err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	if err := user.Password.Set(payload.NewPassword); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.revokeAllSessions(ctx, user.ID); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	// Keys made with the old password could have been made by whoever knew it
+	if err := app.store.APIKeys.DeleteAllByUser(ctx, user.ID); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.audit(c, auditEntry{Action: "user.password_change", TargetType: auditTargetUser, TargetID: user.ID.Hex()})
+
+	tokens, err := app.issueTokens(ctx, user)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, tokens)
+}
+
+type DeleteUserPayload struct {
+	Password string `json:"password"`
+}
+
+// deleteCurrentUserHandler deletes the account together with its personal links,
+// their clicks, API keys, linked identities, workspace memberships and sessions.
+func (app *application) deleteCurrentUserHandler(c echo.Context) error {
+	payload, err := BindAndValidate[DeleteUserPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := checkCurrentPassword(c, user, payload.Password); err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	if err := app.deleteUser(ctx, user); err != nil {
+		switch err {
+		case errSoleWorkspaceOwner:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.audit(c, auditEntry{Action: "user.delete", TargetType: auditTargetUser, TargetID: user.ID.Hex(), Before: user})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deleteUser removes the user and everything that belongs to them. The user
+// document goes last, so a failure halfway can be retried.
+func (app *application) deleteUser(ctx context.Context, user *store.User) error {
+	// Checked first, nothing is deleted when the user still has to hand over a workspace
+	if err := app.leaveAllWorkspaces(ctx, user.ID); err != nil {
+		return err
+	}
+
+	if err := app.revokeAllSessions(ctx, user.ID); err != nil {
+		return err
+	}
+
+	if err := app.store.APIKeys.DeleteAllByUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// Links owned by a workspace stay with it
+	deleted, err := app.store.Urls.DeleteAllByUser(ctx, user.ID)
+	if cleanupErr := app.cleanupDeletedUrls(ctx, deleted); cleanupErr != nil {
+		return cleanupErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := app.store.Identities.DeleteAllByUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	for _, purpose := range []string{store.TokenPurposeVerifyEmail, store.TokenPurposePasswordReset, store.TokenPurposeMFA} {
+		if err := app.store.UserTokens.DeleteAllForUser(ctx, user.ID, purpose); err != nil {
+			return err
+		}
+	}
+
+	if err := app.store.Users.Delete(ctx, user.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	return nil
+}
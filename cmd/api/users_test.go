@@ -0,0 +1,91 @@
+package main
+
+import (
+	"Url-Shortener/internal/sso/ssotest"
+	"Url-Shortener/internal/store"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func contextWithClaims(claims jwt.MapClaims) echo.Context {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodDelete, "/api/v1/users/me", nil), httptest.NewRecorder())
+	if claims != nil {
+		c.Set("claims", claims)
+	}
+	return c
+}
+
+func TestCheckCurrentPasswordWithoutPassword(t *testing.T) {
+	user := &store.User{Email: "ada@example.com"}
+
+	for name, test := range map[string]struct {
+		claims jwt.MapClaims
+		want   error
+	}{
+		"api key":        {claims: nil, want: errReauthRequired},
+		"no auth time":   {claims: jwt.MapClaims{"sub": "1"}, want: errReauthRequired},
+		"old sign in":    {claims: jwt.MapClaims{"auth_time": float64(time.Now().Add(-time.Hour).Unix())}, want: errReauthRequired},
+		"recent sign in": {claims: jwt.MapClaims{"auth_time": float64(time.Now().Unix())}, want: nil},
+		"json number":    {claims: jwt.MapClaims{"auth_time": json.Number("1")}, want: errReauthRequired},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := checkCurrentPassword(contextWithClaims(test.claims), user, ""); err != test.want {
+				t.Errorf("err = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckCurrentPasswordWithPassword(t *testing.T) {
+	user := &store.User{Email: "ada@example.com"}
+	if err := user.Password.Set("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A recent sign in doesn't replace the password
+	c := contextWithClaims(jwt.MapClaims{"auth_time": float64(time.Now().Unix())})
+
+	if err := checkCurrentPassword(c, user, "wrong"); err != errWrongPassword {
+		t.Errorf("err = %v, want errWrongPassword", err)
+	}
+	if err := checkCurrentPassword(c, user, "secret"); err != nil {
+		t.Errorf("err = %v with the right password", err)
+	}
+}
+
+func TestOIDCSessionCarriesAuthTime(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	rec := ot.signIn(t, ssotest.Login{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var res struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ot.app.authenticator.ValidateToken(res.Data.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := ot.users.GetByEmail(t.Context(), "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fresh from the identity provider, a user without a password can confirm changes
+	c := contextWithClaims(token.Claims.(jwt.MapClaims))
+	if err := checkCurrentPassword(c, user, ""); err != nil {
+		t.Errorf("err = %v right after signing in", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/mailer"
+	"Url-Shortener/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// issueUserToken creates a token for purpose, only its hash is kept.
+func (app *application) issueUserToken(ctx context.Context, user *store.User, purpose string, exp time.Duration) (string, error) {
+	plain, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = app.store.UserTokens.Create(ctx, &store.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(exp),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+// frontendLink builds a link to a page of the frontend carrying token.
+func (app *application) frontendLink(path, token string) string {
+	return app.config.frontendURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail mails a new confirmation link, the previous ones stop working.
+func (app *application) sendVerificationEmail(ctx context.Context, user *store.User) error {
+	if err := app.store.UserTokens.DeleteAllForUser(ctx, user.ID, store.TokenPurposeVerifyEmail); err != nil {
+		return err
+	}
+
+	token, err := app.issueUserToken(ctx, user, store.TokenPurposeVerifyEmail, app.config.auth.userTokens.verifyEmailExp)
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, app.frontendLink("/verify-email", token), app.config.auth.userTokens.verifyEmailExp),
+	})
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (app *application) verifyEmailHandler(c echo.Context) error {
+	payload, err := BindAndValidate[VerifyEmailPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	token, err := app.store.UserTokens.Consume(ctx, store.TokenPurposeVerifyEmail, auth.HashToken(payload.Token))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.badRequestResponse(c, errors.New("invalid or expired token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if err := app.store.Users.SetEmailVerified(ctx, token.UserID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.badRequestResponse(c, errors.New("invalid or expired token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.invalidateUser(ctx, token.UserID)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *application) resendVerificationEmailHandler(c echo.Context) error {
+	user := getUserFromContext(c)
+
+	if user.EmailVerified {
+		return app.badRequestResponse(c, errors.New("email address is already verified"))
+	}
+
+	if err := app.sendVerificationEmail(c.Request().Context(), user); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+type PasswordResetRequestPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// requestPasswordResetHandler mails a reset link. It answers the same whether
+// or not the email is registered, so it can't be used to find accounts.
+func (app *application) requestPasswordResetHandler(c echo.Context) error {
+	payload, err := BindAndValidate[PasswordResetRequestPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return c.NoContent(http.StatusAccepted)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	// Sent after answering, so the response takes as long whether the address is registered or not
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+
+		if err := app.sendPasswordResetEmail(ctx, user); err != nil {
+			app.logger.Errorw("failed to send password reset email", "user_id", user.ID.Hex(), "error", err.Error())
+		}
+	})
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *application) sendPasswordResetEmail(ctx context.Context, user *store.User) error {
+	if err := app.store.UserTokens.DeleteAllForUser(ctx, user.ID, store.TokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	exp := app.config.auth.userTokens.passwordResetExp
+
+	token, err := app.issueUserToken(ctx, user, store.TokenPurposePasswordReset, exp)
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, choose a new one here:\n\n%s\n\nThe link expires in %s. If you didn't ask for it, you can ignore this email.\n",
+			user.Username, app.frontendLink("/reset-password", token), exp),
+	})
+}
+
+type PasswordResetConfirmPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// confirmPasswordResetHandler sets the new password and ends every session of the user.
+func (app *application) confirmPasswordResetHandler(c echo.Context) error {
+	payload, err := BindAndValidate[PasswordResetConfirmPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	token, err := app.store.UserTokens.Consume(ctx, store.TokenPurposePasswordReset, auth.HashToken(payload.Token))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.badRequestResponse(c, errors.New("invalid or expired token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	user := &store.User{ID: token.UserID}
+	if err := user.Password.Set(payload.Password); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.badRequestResponse(c, errors.New("invalid or expired token"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if err := app.revokeAllSessions(ctx, user.ID); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.APIKeys.DeleteAllByUser(ctx, user.ID); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	app.audit(c, auditEntry{Action: "user.password_reset", TargetType: auditTargetUser, TargetID: user.ID.Hex(), Actor: user})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// requireVerifiedEmail keeps users who haven't confirmed their email address
+// away from the route. It must run after AuthTokenMiddleware.
+func (app *application) requireVerifiedEmail(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if user := getUserFromContext(c); user == nil || !user.EmailVerified {
+			return app.emailNotVerifiedResponse(c)
+		}
+
+		return next(c)
+	}
+}
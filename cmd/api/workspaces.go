@@ -0,0 +1,456 @@
+package main
+
+import (
+	"Url-Shortener/internal/auth"
+	"Url-Shortener/internal/mailer"
+	"Url-Shortener/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errOwnerRoleRequired  = errors.New("only owners can grant or take away the owner role")
+	errInvitationMismatch = errors.New("the invitation was sent to another email address")
+	errSoleWorkspaceOwner = errors.New("transfer or delete the workspaces you are the only owner of first")
+)
+
+// getWorkspaceFromContext returns the workspace loaded by requireWorkspaceRole.
+func getWorkspaceFromContext(c echo.Context) *store.Workspace {
+	workspace, _ := c.Get("workspace").(*store.Workspace)
+	return workspace
+}
+
+// getMembershipFromContext returns the caller's membership loaded by requireWorkspaceRole.
+func getMembershipFromContext(c echo.Context) *store.Membership {
+	membership, _ := c.Get("membership").(*store.Membership)
+	return membership
+}
+
+type workspaceResponse struct {
+	store.Workspace
+	Role string `json:"role"` // Role of the caller
+}
+
+type WorkspacePayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// createWorkspaceHandler creates a workspace with the caller as its owner.
+func (app *application) createWorkspaceHandler(c echo.Context) error {
+	payload, err := BindAndValidate[WorkspacePayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+
+	workspace := &store.Workspace{
+		Name:      payload.Name,
+		CreatedBy: user.ID,
+	}
+
+	if err := app.store.Workspaces.Create(ctx, workspace); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	err = app.store.Memberships.Create(ctx, &store.Membership{
+		WorkspaceID: workspace.ID,
+		UserID:      user.ID,
+		Role:        store.WorkspaceRoleOwner,
+	})
+	if err != nil {
+		// Nobody could ever manage a workspace without an owner
+		if err := app.store.Workspaces.Delete(ctx, workspace.ID); err != nil {
+			app.logger.Errorw("failed to delete workspace without owner", "workspace", workspace.ID.Hex(), "error", err.Error())
+		}
+		return app.internalServerError(c, err)
+	}
+
+	app.audit(c, auditEntry{Action: "workspace.create", TargetType: auditTargetWorkspace, TargetID: workspace.ID.Hex(), Workspaces: []primitive.ObjectID{workspace.ID}, After: workspace})
+
+	return app.jsonResponse(c, http.StatusCreated, workspaceResponse{*workspace, store.WorkspaceRoleOwner})
+}
+
+// getWorkspacesHandler lists the workspaces the caller is a member of.
+func (app *application) getWorkspacesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+
+	memberships, err := app.store.Memberships.ListByUser(ctx, user.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	roles := make(map[primitive.ObjectID]string, len(memberships))
+	ids := make([]primitive.ObjectID, len(memberships))
+	for i, membership := range memberships {
+		roles[membership.WorkspaceID] = membership.Role
+		ids[i] = membership.WorkspaceID
+	}
+
+	workspaces, err := app.store.Workspaces.ListByIDs(ctx, ids)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	res := make([]workspaceResponse, len(workspaces))
+	for i, workspace := range workspaces {
+		res[i] = workspaceResponse{workspace, roles[workspace.ID]}
+	}
+
+	return app.jsonResponse(c, http.StatusOK, res)
+}
+
+func (app *application) getWorkspaceHandler(c echo.Context) error {
+	workspace := getWorkspaceFromContext(c)
+	membership := getMembershipFromContext(c)
+
+	return app.jsonResponse(c, http.StatusOK, workspaceResponse{*workspace, membership.Role})
+}
+
+func (app *application) renameWorkspaceHandler(c echo.Context) error {
+	payload, err := BindAndValidate[WorkspacePayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	workspace := getWorkspaceFromContext(c)
+	before := *workspace
+
+	if err := app.store.Workspaces.Rename(c.Request().Context(), workspace.ID, payload.Name); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	workspace.Name = payload.Name
+
+	app.audit(c, auditEntry{Action: "workspace.rename", TargetType: auditTargetWorkspace, TargetID: workspace.ID.Hex(), Workspaces: []primitive.ObjectID{workspace.ID}, Before: &before, After: workspace})
+
+	return app.jsonResponse(c, http.StatusOK, workspaceResponse{*workspace, getMembershipFromContext(c).Role})
+}
+
+// deleteWorkspaceHandler deletes the workspace together with its links, their
+// clicks, invitations and members.
+func (app *application) deleteWorkspaceHandler(c echo.Context) error {
+	workspace := getWorkspaceFromContext(c)
+	ctx := c.Request().Context()
+
+	deleted, err := app.store.Urls.DeleteAllByWorkspace(ctx, workspace.ID)
+	if cleanupErr := app.cleanupDeletedUrls(ctx, deleted); cleanupErr != nil {
+		return app.internalServerError(c, cleanupErr)
+	}
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.Invitations.DeleteAllByWorkspace(ctx, workspace.ID); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	// Memberships go last, the owner needs theirs to retry after a failure
+	if err := app.store.Workspaces.Delete(ctx, workspace.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return app.internalServerError(c, err)
+	}
+
+	if err := app.store.Memberships.DeleteAllByWorkspace(ctx, workspace.ID); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	// Kept under the workspace ID, for the platform admins once nobody is left to read it
+	app.audit(c, auditEntry{Action: "workspace.delete", TargetType: auditTargetWorkspace, TargetID: workspace.ID.Hex(), Workspaces: []primitive.ObjectID{workspace.ID}, Before: workspace})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *application) getAllUrlsByWorkspaceHandler(c echo.Context) error {
+	workspace := getWorkspaceFromContext(c)
+
+	return app.listUrls(c, store.UrlPageQuery{WorkspaceID: &workspace.ID})
+}
+
+func (app *application) getWorkspaceMembersHandler(c echo.Context) error {
+	workspace := getWorkspaceFromContext(c)
+
+	members, err := app.store.Memberships.ListByWorkspace(c.Request().Context(), workspace.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, members)
+}
+
+// getMemberByParam loads the membership of the user named by the ":userId" path parameter.
+func (app *application) getMemberByParam(c echo.Context) (*store.Membership, error) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+
+	return app.store.Memberships.Get(c.Request().Context(), getWorkspaceFromContext(c).ID, userID)
+}
+
+type UpdateMemberPayload struct {
+	Role string `json:"role" validate:"required,oneof=viewer editor admin owner"`
+}
+
+func (app *application) updateWorkspaceMemberHandler(c echo.Context) error {
+	payload, err := BindAndValidate[UpdateMemberPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	member, err := app.getMemberByParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	caller := getMembershipFromContext(c)
+	if (payload.Role == store.WorkspaceRoleOwner || member.Role == store.WorkspaceRoleOwner) && caller.Role != store.WorkspaceRoleOwner {
+		return app.badRequestResponse(c, errOwnerRoleRequired)
+	}
+
+	if err := app.store.Memberships.UpdateRole(c.Request().Context(), member.WorkspaceID, member.UserID, payload.Role); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		case store.ErrLastOwner, store.ErrEditConflict:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	before := *member
+	member.Role = payload.Role
+
+	app.audit(c, auditEntry{Action: "workspace.member.update", TargetType: auditTargetMember, TargetID: member.UserID.Hex(), Workspaces: []primitive.ObjectID{member.WorkspaceID}, Before: &before, After: member})
+
+	return app.jsonResponse(c, http.StatusOK, member)
+}
+
+// removeWorkspaceMemberHandler removes a member. Admins remove others, anyone can leave.
+func (app *application) removeWorkspaceMemberHandler(c echo.Context) error {
+	member, err := app.getMemberByParam(c)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	caller := getMembershipFromContext(c)
+	if member.UserID != caller.UserID {
+		if !store.WorkspaceRoleAtLeast(caller.Role, store.WorkspaceRoleAdmin) {
+			return app.forbiddenResponse(c)
+		}
+		if member.Role == store.WorkspaceRoleOwner && caller.Role != store.WorkspaceRoleOwner {
+			return app.badRequestResponse(c, errOwnerRoleRequired)
+		}
+	}
+
+	if err := app.store.Memberships.Delete(c.Request().Context(), member.WorkspaceID, member.UserID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		case store.ErrLastOwner, store.ErrEditConflict:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.audit(c, auditEntry{Action: "workspace.member.remove", TargetType: auditTargetMember, TargetID: member.UserID.Hex(), Workspaces: []primitive.ObjectID{member.WorkspaceID}, Before: member})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type CreateInvitationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  string `json:"role" validate:"required,oneof=viewer editor admin owner"`
+}
+
+// createInvitationHandler mails a link for joining the workspace with the given role.
+func (app *application) createInvitationHandler(c echo.Context) error {
+	payload, err := BindAndValidate[CreateInvitationPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	workspace := getWorkspaceFromContext(c)
+	caller := getMembershipFromContext(c)
+
+	if payload.Role == store.WorkspaceRoleOwner && caller.Role != store.WorkspaceRoleOwner {
+		return app.badRequestResponse(c, errOwnerRoleRequired)
+	}
+
+	plain, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	ctx := c.Request().Context()
+	user := getUserFromContext(c)
+	exp := app.config.auth.userTokens.invitationExp
+
+	invitation := &store.Invitation{
+		WorkspaceID: workspace.ID,
+		Email:       payload.Email,
+		Role:        payload.Role,
+		InvitedBy:   user.ID,
+		TokenHash:   hash,
+		ExpiresAt:   time.Now().Add(exp),
+	}
+
+	if err := app.store.Invitations.Create(ctx, invitation); err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	err = app.mailer.Send(ctx, mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You are invited to join %s", workspace.Name),
+		Body: fmt.Sprintf("Hi,\n\n%s invited you to join the workspace %q as %s. Accept the invitation by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, workspace.Name, invitation.Role, app.frontendLink("/invitations", plain), exp),
+	})
+	if err != nil {
+		// Nobody can accept an invitation that was never delivered
+		if err := app.store.Invitations.Delete(ctx, workspace.ID, invitation.ID); err != nil {
+			app.logger.Errorw("failed to delete undelivered invitation", "invitation", invitation.ID.Hex(), "error", err.Error())
+		}
+		return app.internalServerError(c, err)
+	}
+
+	app.audit(c, auditEntry{Action: "workspace.invitation.create", TargetType: auditTargetInvitation, TargetID: invitation.ID.Hex(), Workspaces: []primitive.ObjectID{workspace.ID}, After: invitation})
+
+	return app.jsonResponse(c, http.StatusCreated, invitation)
+}
+
+// getInvitationsHandler lists the invitations of the workspace that can still be accepted.
+func (app *application) getInvitationsHandler(c echo.Context) error {
+	workspace := getWorkspaceFromContext(c)
+
+	invitations, err := app.store.Invitations.ListByWorkspace(c.Request().Context(), workspace.ID)
+	if err != nil {
+		return app.internalServerError(c, err)
+	}
+
+	return app.jsonResponse(c, http.StatusOK, invitations)
+}
+
+func (app *application) deleteInvitationHandler(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("invitationId"))
+	if err != nil {
+		return app.notFoundResponse(c, store.ErrNotFound)
+	}
+
+	workspace := getWorkspaceFromContext(c)
+
+	if err := app.store.Invitations.Delete(c.Request().Context(), workspace.ID, id); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.notFoundResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	app.audit(c, auditEntry{Action: "workspace.invitation.delete", TargetType: auditTargetInvitation, TargetID: id.Hex(), Workspaces: []primitive.ObjectID{workspace.ID}})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AcceptInvitationPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// acceptInvitationHandler makes the caller a member of the workspace they were
+// invited to. The invitation must have been sent to their verified email address.
+func (app *application) acceptInvitationHandler(c echo.Context) error {
+	payload, err := BindAndValidate[AcceptInvitationPayload](c)
+
+	if err != nil {
+		return app.badRequestResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	invitation, err := app.store.Invitations.GetByHash(ctx, auth.HashToken(payload.Token))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return app.badRequestResponse(c, errors.New("invalid or expired invitation"))
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	user := getUserFromContext(c)
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		return app.badRequestResponse(c, errInvitationMismatch)
+	}
+
+	membership := &store.Membership{
+		WorkspaceID: invitation.WorkspaceID,
+		UserID:      user.ID,
+		Role:        invitation.Role,
+	}
+
+	if err := app.store.Memberships.Create(ctx, membership); err != nil {
+		switch err {
+		case store.ErrAlreadyMember:
+			return app.conflictResponse(c, err)
+		default:
+			return app.internalServerError(c, err)
+		}
+	}
+
+	if err := app.store.Invitations.Delete(ctx, invitation.WorkspaceID, invitation.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.logger.Errorw("failed to delete accepted invitation", "invitation", invitation.ID.Hex(), "error", err.Error())
+	}
+
+	app.audit(c, auditEntry{Action: "workspace.invitation.accept", TargetType: auditTargetMember, TargetID: user.ID.Hex(), Workspaces: []primitive.ObjectID{membership.WorkspaceID}, After: membership})
+
+	return app.jsonResponse(c, http.StatusCreated, membership)
+}
+
+// leaveAllWorkspaces ends the user's memberships before their account goes away.
+// It refuses while the user is the only owner of a workspace, which would be
+// left without anyone able to manage it.
+func (app *application) leaveAllWorkspaces(ctx context.Context, userID primitive.ObjectID) error {
+	sole, err := app.store.Memberships.SoleOwnerOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(sole) > 0 {
+		return errSoleWorkspaceOwner
+	}
+
+	// Another owner may have left since
+	err = app.store.Memberships.DeleteAllByUser(ctx, userID)
+	if errors.Is(err, store.ErrLastOwner) {
+		return errSoleWorkspaceOwner
+	}
+	return err
+}
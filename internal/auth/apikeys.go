@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// API keys look like "usk_<prefix>_<secret>". The prefix is stored in clear to find
+// the key (and to recognise it in logs or secret scanners), the secret only as a hash.
+const (
+	APIKeyScheme    = "usk_"
+	apiKeyPrefixLen = 12
+)
+
+var ErrMalformedAPIKey = errors.New("malformed api key")
+
+// GenerateAPIKey returns a new key, its public prefix and the hash to store.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, apiKeyPrefixLen/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(b)
+
+	secret, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key = APIKeyScheme + prefix + "_" + secret
+	return key, prefix, HashToken(key), nil
+}
+
+// ParseAPIKey extracts the prefix of a key without checking the secret.
+func ParseAPIKey(key string) (prefix string, err error) {
+	rest, ok := strings.CutPrefix(key, APIKeyScheme)
+	if !ok || len(rest) <= apiKeyPrefixLen+1 || rest[apiKeyPrefixLen] != '_' {
+		return "", ErrMalformedAPIKey
+	}
+
+	return rest[:apiKeyPrefixLen], nil
+}
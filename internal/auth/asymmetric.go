@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type verificationKey struct {
+	key    crypto.PublicKey
+	method jwt.SigningMethod
+}
+
+// AsymmetricJWTAuthenticator signs with a private RSA (RS256) or Ed25519 (EdDSA) key
+// and verifies against a set of public keys selected by the "kid" header. Keeping the
+// previous public keys in the set lets tokens signed before a key rotation stay valid
+// until they expire. Other services can verify tokens through the published JWKS.
+type AsymmetricJWTAuthenticator struct {
+	signingKey    crypto.Signer
+	signingKid    string
+	signingMethod jwt.SigningMethod
+	keys          map[string]verificationKey
+	jwks          JWKSet
+	aud           string
+	iss           string
+}
+
+func NewAsymmetricJWTAuthenticator(signingKey crypto.Signer, previousKeys []crypto.PublicKey, aud, iss string) (*AsymmetricJWTAuthenticator, error) {
+	a := &AsymmetricJWTAuthenticator{
+		signingKey: signingKey,
+		keys:       make(map[string]verificationKey),
+		jwks:       JWKSet{Keys: []JWK{}},
+		aud:        aud,
+		iss:        iss,
+	}
+
+	for i, key := range append([]crypto.PublicKey{signingKey.Public()}, previousKeys...) {
+		method, err := signingMethodFor(key)
+		if err != nil {
+			return nil, err
+		}
+
+		jwk, err := newJWK(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			a.signingKid = jwk.Kid
+			a.signingMethod = method
+		}
+		if _, ok := a.keys[jwk.Kid]; ok {
+			continue
+		}
+
+		a.keys[jwk.Kid] = verificationKey{key: key, method: method}
+		a.jwks.Keys = append(a.jwks.Keys, jwk)
+	}
+
+	return a, nil
+}
+
+func (a *AsymmetricJWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(a.signingMethod, claims)
+	token.Header["kid"] = a.signingKid
+
+	return token.SignedString(a.signingKey)
+}
+
+func (a *AsymmetricJWTAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, ok := a.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+
+		return key.key, nil
+	},
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(a.aud),
+		jwt.WithIssuer(a.iss),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+	)
+}
+
+func (a *AsymmetricJWTAuthenticator) KeySet() JWKSet {
+	return a.jwks
+}
+
+func signingMethodFor(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, expected RSA or Ed25519", key)
+	}
+}
+
+// LoadSigningKey reads an RSA or Ed25519 private key from a PEM file.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, errors.New("not an RSA or Ed25519 private key"))
+	}
+
+	return key.(crypto.Signer), nil
+}
+
+// LoadPublicKey reads an RSA or Ed25519 public key from a PEM file.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	key, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, errors.New("not an RSA or Ed25519 public key"))
+	}
+
+	return key, nil
+}
@@ -5,4 +5,6 @@ import "github.com/golang-jwt/jwt/v5"
 type Authenticator interface {
 	GenerateToken(claims jwt.Claims) (string, error)
 	ValidateToken(token string) (*jwt.Token, error)
+	// KeySet returns the public keys tokens can be verified with, empty for shared secrets.
+	KeySet() JWKSet
 }
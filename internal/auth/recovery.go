@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns n one-time codes formatted as "xxxx-xxxx-xxxx-xxxx"
+// together with the hashes to store in their place.
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for range n {
+		b := make([]byte, 10) // 80 bits, 16 base32 characters
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code the way the user typed it, ignoring case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashToken(code)
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeUrlsRead      = "urls:read"
+	ScopeUrlsWrite     = "urls:write"
+	ScopeUrlsDelete    = "urls:delete"
+	ScopeStatsRead     = "stats:read"
+	ScopeKeysManage    = "keys:manage"
+	ScopeAccountManage = "account:manage"
+	ScopeAdmin         = "admin" // Only in tokens of users with the admin role
+)
+
+// UserScopes are granted to tokens from an interactive login.
+var UserScopes = []string{
+	ScopeUrlsRead,
+	ScopeUrlsWrite,
+	ScopeUrlsDelete,
+	ScopeStatsRead,
+	ScopeKeysManage,
+	ScopeAccountManage,
+}
+
+// APIKeyScopes can be granted to API keys. Managing keys and the account is
+// left out so a leaked key can't be used to mint more keys or take over the account.
+var APIKeyScopes = []string{
+	ScopeUrlsRead,
+	ScopeUrlsWrite,
+	ScopeUrlsDelete,
+	ScopeStatsRead,
+}
+
+func IsAPIKeyScope(scope string) bool {
+	return slices.Contains(APIKeyScopes, scope)
+}
+
+// FormatScopes renders scopes for the space separated "scope" claim.
+func FormatScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func ParseScopes(claim string) []string {
+	return strings.Fields(claim)
+}
+
+// HasScopes reports whether granted contains every one of required.
+func HasScopes(granted []string, required ...string) bool {
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
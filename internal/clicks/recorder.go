@@ -0,0 +1,181 @@
+package clicks
+
+import (
+	"Url-Shortener/internal/store"
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrQueueFull = errors.New("clicks: queue is full")
+	ErrClosed    = errors.New("clicks: recorder is closed")
+)
+
+var (
+	recordedClicks = expvar.NewInt("clicks_recorded")
+	droppedClicks  = expvar.NewInt("clicks_dropped")
+)
+
+type Config struct {
+	QueueSize      int           // Events buffered in memory before Record starts pushing back
+	BatchSize      int           // Max events written per flush
+	FlushInterval  time.Duration // Max time an event waits in a partial batch
+	EnqueueTimeout time.Duration // How long Record waits for room in a full queue
+	Workers        int
+}
+
+// Recorder buffers click events and writes them to the store in batches, off the
+// redirect path. Events that can't be queued within EnqueueTimeout are dropped.
+type Recorder struct {
+	store  store.Storage
+	cfg    Config
+	logger *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	closed bool
+	events chan store.Click
+	wg     sync.WaitGroup
+}
+
+func NewRecorder(s store.Storage, cfg Config, logger *zap.SugaredLogger) *Recorder {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10_000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+
+	r := &Recorder{
+		store:  s,
+		cfg:    cfg,
+		logger: logger,
+		events: make(chan store.Click, cfg.QueueSize),
+	}
+
+	r.wg.Add(cfg.Workers)
+	for range cfg.Workers {
+		go r.worker()
+	}
+
+	return r
+}
+
+// Record queues a click. It never blocks longer than EnqueueTimeout.
+func (r *Recorder) Record(click store.Click) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return ErrClosed
+	}
+
+	select {
+	case r.events <- click:
+		return nil
+	default:
+	}
+
+	if r.cfg.EnqueueTimeout > 0 {
+		t := time.NewTimer(r.cfg.EnqueueTimeout)
+		defer t.Stop()
+
+		select {
+		case r.events <- click:
+			return nil
+		case <-t.C:
+		}
+	}
+
+	droppedClicks.Add(1)
+	return ErrQueueFull
+}
+
+// Close stops accepting clicks and waits until everything queued so far
+// has been flushed, or ctx is done.
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Recorder) worker() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]store.Click, 0, r.cfg.BatchSize)
+
+	for {
+		select {
+		case click, ok := <-r.events:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+
+			batch = append(batch, click)
+			if len(batch) >= r.cfg.BatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (r *Recorder) flush(batch []store.Click) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+
+	visits := make(map[primitive.ObjectID]uint64)
+	for _, click := range batch {
+		visits[click.URLID]++
+	}
+
+	if err := r.store.Clicks.InsertMany(ctx, batch); err != nil {
+		r.logger.Errorw("failed to store clicks", "count", len(batch), "error", err.Error())
+	}
+
+	if err := r.store.Urls.IncrementVisits(ctx, visits); err != nil {
+		r.logger.Errorw("failed to increment visit counts", "urls", len(visits), "error", err.Error())
+		return
+	}
+
+	recordedClicks.Add(int64(len(batch)))
+}
@@ -1,9 +1,12 @@
 package database
 
 import (
+	"Url-Shortener/internal/store"
 	"context"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
@@ -41,6 +44,10 @@ func New(host, port, name, username, password string) (*mongo.Client, error) {
 		return nil, err
 	}
 
+	if err := migrate(client.Database(name)); err != nil {
+		return nil, fmt.Errorf("failed to migrate: %w", err)
+	}
+
 	return client, nil
 }
 
@@ -77,13 +84,298 @@ func ensureUrlIndexes(urlsCollection *mongo.Collection) error {
 			Keys:    bson.M{"user_id": 1},
 			Options: options.Index().SetName("by_user_id"),
 		},
+		{
+			Keys:    bson.M{"workspace_id": 1},
+			Options: options.Index().SetSparse(true).SetName("by_workspace_id"),
+		},
 		{
 			Keys:    bson.M{"expires_at": 1},
 			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_index"), // optional TTL if using expiry
 		},
+		// Paging through a user's or a workspace's links in each sort order
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("by_user_id_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "visit_count", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("by_user_id_visit_count"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "expires_at", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("by_user_id_expires_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "domain", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("by_user_id_domain"),
+		},
+		{
+			Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().
+				SetPartialFilterExpression(bson.M{"workspace_id": bson.M{"$exists": true}}).
+				SetName("by_workspace_id_created_at"),
+		},
+		// Prefixed by the owner, so a search only reads the entries of one user or workspace
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "original_url", Value: "text"}, {Key: "short_code", Value: "text"}},
+			Options: options.Index().
+				SetWeights(bson.M{"short_code": 2, "original_url": 1}).
+				SetName("owner_text_search"),
+		},
+	}
+
+	// A collection has at most one text index, the unprefixed one from older versions goes first
+	var cmdErr mongo.CommandError
+	_, err := urlsCollection.Indexes().DropOne(ctx, "text_search")
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound") {
+		return err
+	}
+
+	_, err = urlsCollection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func ensureClickIndexes(clicksCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "url_id", Value: 1}, {Key: "occurred_at", Value: 1}},
+			Options: options.Index().SetName("by_url_id_occurred_at"),
+		},
+	}
+
+	_, err := clicksCollection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func ensureTokenIndexes(refreshTokensCollection, revokedTokensCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	refreshIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"token_hash": 1},
+			Options: options.Index().SetUnique(true).SetName("unique_token_hash"),
+		},
+		{
+			Keys:    bson.M{"user_id": 1},
+			Options: options.Index().SetName("by_user_id"),
+		},
+		{
+			Keys:    bson.M{"family_id": 1},
+			Options: options.Index().SetName("by_family_id"),
+		},
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_index"),
+		},
+	}
+
+	if _, err := refreshTokensCollection.Indexes().CreateMany(ctx, refreshIndexes); err != nil {
+		return err
+	}
+
+	revokedIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_index"),
+		},
+	}
+
+	_, err := revokedTokensCollection.Indexes().CreateMany(ctx, revokedIndexes)
+	return err
+}
+
+func ensureAPIKeyIndexes(apiKeysCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"prefix": 1},
+			Options: options.Index().SetUnique(true).SetName("unique_prefix"),
+		},
+		{
+			Keys:    bson.M{"user_id": 1},
+			Options: options.Index().SetName("by_user_id"),
+		},
+	}
+
+	_, err := apiKeysCollection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func ensureOIDCIndexes(statesCollection, identitiesCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stateIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_index"),
+		},
+	}
+
+	if _, err := statesCollection.Indexes().CreateMany(ctx, stateIndexes); err != nil {
+		return err
+	}
+
+	identityIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_provider_subject"),
+		},
+		{
+			Keys:    bson.M{"user_id": 1},
+			Options: options.Index().SetName("by_user_id"),
+		},
 	}
 
-	_, err := urlsCollection.Indexes().CreateMany(ctx, indexes)
+	_, err := identitiesCollection.Indexes().CreateMany(ctx, identityIndexes)
+	return err
+}
+
+func ensureUserTokenIndexes(userTokensCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"token_hash": 1},
+			Options: options.Index().SetUnique(true).SetName("unique_token_hash"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}},
+			Options: options.Index().SetName("by_user_id_purpose"),
+		},
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_index"),
+		},
+	}
+
+	_, err := userTokensCollection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func ensureLoginEventIndexes(loginEventsCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("by_user_id_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("by_email_created_at"),
+		},
+		{
+			Keys:    bson.M{"created_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(90 * 24 * 60 * 60).SetName("retention_index"), // 90 days
+		},
+	}
+
+	_, err := loginEventsCollection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func ensureWorkspaceIndexes(membersCollection, invitationsCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	memberIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "workspace_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_workspace_user"),
+		},
+		{
+			Keys:    bson.M{"user_id": 1},
+			Options: options.Index().SetName("by_user_id"),
+		},
+	}
+
+	if _, err := membersCollection.Indexes().CreateMany(ctx, memberIndexes); err != nil {
+		return err
+	}
+
+	invitationIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"token_hash": 1},
+			Options: options.Index().SetUnique(true).SetName("unique_token_hash"),
+		},
+		{
+			Keys:    bson.M{"workspace_id": 1},
+			Options: options.Index().SetName("by_workspace_id"),
+		},
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_index"),
+		},
+	}
+
+	_, err := invitationsCollection.Indexes().CreateMany(ctx, invitationIndexes)
+	return err
+}
+
+func ensureTransferIndexes(collection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			// One pending transfer per link, resolved ones are kept as history
+			Keys: bson.M{"short_url_id": 1},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": "pending"}).
+				SetName("unique_pending_transfer"),
+		},
+		{
+			Keys:    bson.D{{Key: "short_url_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("by_short_url_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "to.user_id", Value: 1}},
+			Options: options.Index().SetName("pending_by_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "to.workspace_id", Value: 1}},
+			Options: options.Index().SetName("pending_by_workspace"),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func ensureAuditIndexes(collection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "workspace_ids", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("by_workspace_ids"),
+		},
+		{
+			Keys:    bson.D{{Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("by_actor_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("by_target"),
+		},
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("retention_index"),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
 	return err
 }
 
@@ -99,5 +391,197 @@ func ensureIndexes(db *mongo.Database) error {
 		return err
 	}
 
+	err = ensureClickIndexes(db.Collection("clicks"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureTokenIndexes(db.Collection("refresh_tokens"), db.Collection("revoked_tokens"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureAPIKeyIndexes(db.Collection("api_keys"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureOIDCIndexes(db.Collection("oidc_states"), db.Collection("identities"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureUserTokenIndexes(db.Collection("user_tokens"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureLoginEventIndexes(db.Collection("login_events"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureWorkspaceIndexes(db.Collection("workspace_members"), db.Collection("workspace_invitations"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureTransferIndexes(db.Collection("transfers"))
+	if err != nil {
+		return err
+	}
+
+	err = ensureAuditIndexes(db.Collection("audit_events"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrate brings documents written by older versions up to date. Every step
+// must be safe to run again on each start.
+func migrate(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Users from before email verification keep their access
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := lowercaseUserEmails(db.Collection("users")); err != nil {
+		return err
+	}
+
+	if err := backfillOwnerCounts(db.Collection("workspaces"), db.Collection("workspace_members")); err != nil {
+		return err
+	}
+
+	// Links from before the text index was prefixed by the owner
+	_, err = db.Collection("urls").UpdateMany(ctx,
+		bson.M{"owner_id": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"owner_id": bson.M{"$ifNull": bson.A{"$workspace_id", "$user_id"}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return backfillUrlDomains(db.Collection("urls"))
+}
+
+// lowercaseUserEmails stores the emails of users from before they were
+// normalized in lower case, the way they are looked up now. An address that
+// only differs in case from another user's is left alone, the unique index
+// refuses it and an admin has to decide which account keeps it.
+func lowercaseUserEmails(usersCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cursor, err := usersCollection.Find(ctx,
+		bson.M{"email": bson.M{"$regex": "[A-Z]|^\\s|\\s$"}},
+		options.Find().SetProjection(bson.M{"email": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Email string             `bson:"email"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		_, err := usersCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{"email": store.NormalizeEmail(user.Email)}},
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// backfillOwnerCounts counts the owners of workspaces created before the count was kept.
+func backfillOwnerCounts(workspacesCollection, membersCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	ids, err := workspacesCollection.Distinct(ctx, "_id", bson.M{"owner_count": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		owners, err := membersCollection.CountDocuments(ctx, bson.M{"workspace_id": id, "role": "owner"})
+		if err != nil {
+			return err
+		}
+
+		_, err = workspacesCollection.UpdateOne(ctx,
+			bson.M{"_id": id, "owner_count": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"owner_count": owners}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// backfillUrlDomains sets the domain of links created before it was stored.
+func backfillUrlDomains(urlsCollection *mongo.Collection) error {
+	// Only slow the first start after an upgrade, later ones find nothing to do
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cursor, err := urlsCollection.Find(ctx,
+		bson.M{"domain": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"original_url": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var updates []mongo.WriteModel
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			OriginalURL string             `bson:"original_url"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"domain": store.URLDomain(doc.OriginalURL)}}))
+
+		if len(updates) == 1000 {
+			if _, err := urlsCollection.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false)); err != nil {
+				return err
+			}
+			updates = updates[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(updates) > 0 {
+		_, err = urlsCollection.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Resolver maps an IP address to an ISO 3166-1 alpha-2 country code,
+// returning an empty string when the country is unknown.
+type Resolver interface {
+	Country(ip net.IP) string
+}
+
+// MaxMindResolver looks countries up in a local GeoLite2/GeoIP2 Country (or City) database file.
+type MaxMindResolver struct {
+	db *geoip2.Reader
+}
+
+func Open(path string) (*MaxMindResolver, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MaxMindResolver{db: db}, nil
+}
+
+func (r *MaxMindResolver) Country(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	record, err := r.db.Country(ip)
+	if err != nil {
+		return ""
+	}
+
+	return record.Country.IsoCode
+}
+
+func (r *MaxMindResolver) Close() error {
+	return r.db.Close()
+}
+
+// NopResolver is used when no database is configured.
+type NopResolver struct{}
+
+func (NopResolver) Country(net.IP) string {
+	return ""
+}
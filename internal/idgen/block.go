@@ -0,0 +1,58 @@
+package idgen
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RangeReserver atomically reserves size consecutive IDs for the named sequence
+// and returns the last ID of the reserved range.
+type RangeReserver interface {
+	Reserve(ctx context.Context, name string, size int64) (int64, error)
+}
+
+// BlockAllocator hands out IDs from ranges reserved through a shared RangeReserver,
+// so several processes can draw from one sequence without coordinating per ID.
+// IDs left in a block when the process stops are simply never used.
+type BlockAllocator struct {
+	mu sync.Mutex
+
+	reserver  RangeReserver
+	name      string
+	blockSize int64
+	next      int64
+	end       int64
+}
+
+func NewBlockAllocator(reserver RangeReserver, name string, blockSize int64) (*BlockAllocator, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("idgen: block size must be positive, got %d", blockSize)
+	}
+
+	return &BlockAllocator{
+		reserver:  reserver,
+		name:      name,
+		blockSize: blockSize,
+	}, nil
+}
+
+func (b *BlockAllocator) NextID(ctx context.Context) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.next == 0 || b.next > b.end {
+		end, err := b.reserver.Reserve(ctx, b.name, b.blockSize)
+		if err != nil {
+			return 0, fmt.Errorf("idgen: reserving block for %q: %w", b.name, err)
+		}
+
+		b.next = end - b.blockSize + 1
+		b.end = end
+	}
+
+	id := b.next
+	b.next++
+
+	return id, nil
+}
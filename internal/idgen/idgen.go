@@ -0,0 +1,9 @@
+package idgen
+
+import "context"
+
+// Generator hands out unique, positive int64 identifiers.
+// Implementations must be safe for concurrent use.
+type Generator interface {
+	NextID(ctx context.Context) (int64, error)
+}
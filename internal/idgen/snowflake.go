@@ -0,0 +1,123 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultEpoch is the custom epoch used when SnowflakeConfig.Epoch is zero.
+// Starting closer to "now" keeps the generated IDs (and their base62 form) short.
+var DefaultEpoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrClockMovedBackwards = errors.New("idgen: clock moved backwards")
+
+type SnowflakeConfig struct {
+	Epoch        time.Time
+	NodeID       int64
+	NodeBits     uint8
+	SequenceBits uint8
+}
+
+// Snowflake generates IDs laid out as | timestamp (ms) | node | sequence |.
+// Two generators never collide as long as they are configured with different node IDs.
+type Snowflake struct {
+	mu sync.Mutex
+
+	epoch        time.Time
+	nodeID       int64
+	nodeShift    uint8
+	timeShift    uint8
+	maxSequence  int64
+	lastMillis   int64
+	sequence     int64
+	maxClockSkew time.Duration
+}
+
+func NewSnowflake(cfg SnowflakeConfig) (*Snowflake, error) {
+	if cfg.Epoch.IsZero() {
+		cfg.Epoch = DefaultEpoch
+	}
+	if cfg.NodeBits == 0 {
+		cfg.NodeBits = 10
+	}
+	if cfg.SequenceBits == 0 {
+		cfg.SequenceBits = 12
+	}
+	// Leave at least 41 bits for the timestamp (~69 years of milliseconds).
+	if int(cfg.NodeBits)+int(cfg.SequenceBits) > 22 {
+		return nil, fmt.Errorf("idgen: node bits + sequence bits must not exceed 22, got %d", cfg.NodeBits+cfg.SequenceBits)
+	}
+
+	maxNode := int64(1)<<cfg.NodeBits - 1
+	if cfg.NodeID < 0 || cfg.NodeID > maxNode {
+		return nil, fmt.Errorf("idgen: node id must be between 0 and %d, got %d", maxNode, cfg.NodeID)
+	}
+
+	return &Snowflake{
+		epoch:        cfg.Epoch,
+		nodeID:       cfg.NodeID,
+		nodeShift:    cfg.SequenceBits,
+		timeShift:    cfg.SequenceBits + cfg.NodeBits,
+		maxSequence:  int64(1)<<cfg.SequenceBits - 1,
+		lastMillis:   -1,
+		maxClockSkew: 5 * time.Second,
+	}, nil
+}
+
+func (s *Snowflake) NextID(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.millis()
+
+	if now < s.lastMillis {
+		// Small NTP adjustments are waited out, anything bigger is refused
+		// because it would mean re-issuing IDs we already handed out.
+		skew := time.Duration(s.lastMillis-now) * time.Millisecond
+		if skew > s.maxClockSkew {
+			return 0, fmt.Errorf("%w by %s", ErrClockMovedBackwards, skew)
+		}
+		if err := sleepCtx(ctx, skew); err != nil {
+			return 0, err
+		}
+		now = s.millis()
+	}
+
+	if now == s.lastMillis {
+		s.sequence = (s.sequence + 1) & s.maxSequence
+		if s.sequence == 0 {
+			// Sequence exhausted for this millisecond, wait for the next one.
+			for now <= s.lastMillis {
+				if err := sleepCtx(ctx, time.Millisecond/10); err != nil {
+					return 0, err
+				}
+				now = s.millis()
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+
+	s.lastMillis = now
+
+	return now<<s.timeShift | s.nodeID<<s.nodeShift | s.sequence, nil
+}
+
+func (s *Snowflake) millis() int64 {
+	return time.Since(s.epoch).Milliseconds()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+// Package loginguard slows down password guessing. Failed logins are counted
+// per account and per client IP, and once a key runs out of free attempts every
+// further failure locks it for twice as long as the previous one.
+//
+// Attempts are reserved before the password is checked, so a burst of
+// concurrent guesses can't all slip through before the first one is counted.
+package loginguard
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Policy is how many failures a key is allowed and how it is locked after that.
+type Policy struct {
+	FreeAttempts int           // Failures before the first lockout
+	BaseDelay    time.Duration // First lockout, doubled on every further failure
+	MaxDelay     time.Duration
+	Window       time.Duration // Failures are forgotten after this long without a new one
+}
+
+// delay is the lockout after the given number of failures, zero while there are free attempts left.
+func (p Policy) delay(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < over && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+const (
+	// pendingTimeout drops reservations that were never settled, say by a crashed request.
+	pendingTimeout = 10 * time.Second
+	// pendingRetry is the wait suggested while other attempts are deciding a lockout.
+	pendingRetry = time.Second
+)
+
+type Config struct {
+	Account Policy
+	IP      Policy // Usually more lenient, many users can share an address
+}
+
+// Store keeps the failure counters.
+type Store interface {
+	// Attempt checks key and reserves an attempt in one step. It returns how
+	// long to wait, without reserving, when key is locked or when the attempts
+	// already in flight could use up the free ones if they all failed.
+	Attempt(ctx context.Context, key string, policy Policy) (time.Duration, error)
+	// Fail turns a reserved attempt into a failure and returns how long key is now locked for.
+	Fail(ctx context.Context, key string, policy Policy) (time.Duration, error)
+	// Release gives back a reserved attempt that didn't fail.
+	Release(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+type Guard struct {
+	store Store
+	cfg   Config
+}
+
+func New(store Store, cfg Config) *Guard {
+	return &Guard{store: store, cfg: cfg}
+}
+
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(account))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Attempt reserves a login to account from ip and returns how long it is
+// refused for, zero when it is allowed. An allowed attempt must be settled
+// with Fail, Succeed or Release.
+func (g *Guard) Attempt(ctx context.Context, account, ip string) (time.Duration, error) {
+	wait, err := g.store.Attempt(ctx, accountKey(account), g.cfg.Account)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	wait, err = g.store.Attempt(ctx, ipKey(ip), g.cfg.IP)
+	if err == nil && wait > 0 {
+		// Refused after all, give the account's reservation back
+		err = g.store.Release(ctx, accountKey(account))
+	}
+
+	return wait, err
+}
+
+// Fail records a failed login and returns how long the next one will be refused.
+// Unknown accounts are counted too, so lockouts don't reveal which emails are registered.
+func (g *Guard) Fail(ctx context.Context, account, ip string) (time.Duration, error) {
+	accountLock, err := g.store.Fail(ctx, accountKey(account), g.cfg.Account)
+	if err != nil {
+		return 0, err
+	}
+
+	ipLock, err := g.store.Fail(ctx, ipKey(ip), g.cfg.IP)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(accountLock, ipLock), nil
+}
+
+// Succeed clears the account's failures after a successful login. The IP keeps
+// its count, one good password shouldn't excuse guessing at other accounts.
+func (g *Guard) Succeed(ctx context.Context, account, ip string) error {
+	if err := g.store.Reset(ctx, accountKey(account)); err != nil {
+		return err
+	}
+
+	return g.store.Release(ctx, ipKey(ip))
+}
+
+// Release settles an attempt that neither failed nor succeeded, like one cut
+// short by an internal error.
+func (g *Guard) Release(ctx context.Context, account, ip string) error {
+	if err := g.store.Release(ctx, accountKey(account)); err != nil {
+		return err
+	}
+
+	return g.store.Release(ctx, ipKey(ip))
+}
+
+// Unlock lifts the lockout of an account, and of the addresses it logs in from, early.
+func (g *Guard) Unlock(ctx context.Context, account string, ips ...string) error {
+	if err := g.store.Reset(ctx, accountKey(account)); err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if err := g.store.Reset(ctx, ipKey(ip)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Minute,
+	MaxDelay:     time.Hour,
+	Window:       15 * time.Minute,
+}
+
+// forEachStore runs test against the memory store and a Redis store.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		store := NewMemoryStore()
+		t.Cleanup(store.Stop)
+		test(t, store)
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		test(t, NewRedisStore(rdb))
+	})
+}
+
+func newTestGuard(store Store) *Guard {
+	return New(store, Config{Account: testPolicy, IP: Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       15 * time.Minute,
+	}})
+}
+
+func TestGuardLocksAfterFreeAttempts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		g := newTestGuard(store)
+		ctx := context.Background()
+
+		for i := range testPolicy.FreeAttempts + 1 {
+			if wait, err := g.Attempt(ctx, "ada@example.com", "1.2.3.4"); err != nil || wait != 0 {
+				t.Fatalf("attempt %d: wait = %v, err = %v, want allowed", i+1, wait, err)
+			}
+			if _, err := g.Fail(ctx, "ada@example.com", "1.2.3.4"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wait, err := g.Attempt(ctx, "ADA@example.com ", "5.6.7.8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait <= 0 || wait > time.Minute {
+			t.Errorf("wait = %v, want the first lockout", wait)
+		}
+
+		// Other accounts from the same address aren't locked yet
+		if wait, _ := g.Attempt(ctx, "bob@example.com", "1.2.3.4"); wait != 0 {
+			t.Errorf("other account refused for %v", wait)
+		}
+	})
+}
+
+func TestGuardConcurrentAttemptsAreReserved(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		g := newTestGuard(store)
+		ctx := context.Background()
+
+		// Every guess is wrong, but none is settled before the others are checked
+		var allowed atomic.Int64
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if wait, err := g.Attempt(ctx, "ada@example.com", "1.2.3.4"); err == nil && wait == 0 {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if n := allowed.Load(); n != int64(testPolicy.FreeAttempts+1) {
+			t.Errorf("%d concurrent attempts allowed, want %d", n, testPolicy.FreeAttempts+1)
+		}
+	})
+}
+
+func TestGuardReleaseAndSucceedFreeReservations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		g := newTestGuard(store)
+		ctx := context.Background()
+
+		for range testPolicy.FreeAttempts + 1 {
+			g.Attempt(ctx, "ada@example.com", "1.2.3.4")
+		}
+		if wait, _ := g.Attempt(ctx, "ada@example.com", "1.2.3.4"); wait != pendingRetry {
+			t.Fatalf("wait = %v while attempts are in flight, want %v", wait, pendingRetry)
+		}
+
+		g.Release(ctx, "ada@example.com", "1.2.3.4")
+		if err := g.Succeed(ctx, "ada@example.com", "1.2.3.4"); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range testPolicy.FreeAttempts + 1 {
+			if wait, _ := g.Attempt(ctx, "ada@example.com", "1.2.3.4"); wait != 0 {
+				t.Fatalf("attempt %d after settling refused for %v", i+1, wait)
+			}
+		}
+	})
+}
+
+func TestGuardUnlock(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		g := New(store, Config{Account: testPolicy, IP: testPolicy})
+		ctx := context.Background()
+
+		// Lock the address with guesses at other accounts, and the account itself
+		for _, account := range []string{"a@example.com", "b@example.com", "c@example.com", "ada@example.com"} {
+			g.Attempt(ctx, account, "1.2.3.4")
+			g.Fail(ctx, account, "1.2.3.4")
+		}
+		for range testPolicy.FreeAttempts {
+			g.Attempt(ctx, "ada@example.com", "5.6.7.8")
+			g.Fail(ctx, "ada@example.com", "5.6.7.8")
+		}
+
+		if wait, _ := g.Attempt(ctx, "ada@example.com", "5.6.7.8"); wait == 0 {
+			t.Fatal("account not locked")
+		}
+		if wait, _ := g.Attempt(ctx, "bob@example.com", "1.2.3.4"); wait == 0 {
+			t.Fatal("address not locked")
+		}
+
+		if err := g.Unlock(ctx, "ada@example.com", "1.2.3.4"); err != nil {
+			t.Fatal(err)
+		}
+
+		if wait, _ := g.Attempt(ctx, "ada@example.com", "1.2.3.4"); wait != 0 {
+			t.Errorf("refused for %v after unlock", wait)
+		}
+	})
+}
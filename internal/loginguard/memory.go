@@ -0,0 +1,139 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	window      time.Duration
+
+	pending      int // Reserved attempts not settled yet
+	pendingUntil time.Time
+}
+
+// MemoryStore keeps counters in the process, so every replica counts on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		entries: make(map[string]*entry),
+		stop:    make(chan struct{}),
+	}
+
+	go s.janitor(time.Minute)
+
+	return s
+}
+
+// janitor forgets keys whose failures and lockout have both run out.
+func (s *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, e := range s.entries {
+				if now.Sub(e.lastFailure) > e.window && now.After(e.lockedUntil) && now.After(e.pendingUntil) {
+					delete(s.entries, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// entry returns the counters of key as of now, forgetting failures outside
+// the window and reservations that timed out. Callers hold s.mu.
+func (s *MemoryStore) entry(key string, now time.Time, window time.Duration) *entry {
+	e, ok := s.entries[key]
+	if !ok {
+		e = &entry{}
+		s.entries[key] = e
+	}
+
+	if now.Sub(e.lastFailure) > window {
+		e.failures = 0
+	}
+	if now.After(e.pendingUntil) {
+		e.pending = 0
+	}
+	e.window = window
+
+	return e
+}
+
+func (s *MemoryStore) Attempt(_ context.Context, key string, policy Policy) (time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key, now, policy.Window)
+
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now), nil
+	}
+	if e.pending > 0 && e.failures+e.pending > policy.FreeAttempts {
+		return pendingRetry, nil
+	}
+
+	e.pending++
+	e.pendingUntil = now.Add(pendingTimeout)
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Fail(_ context.Context, key string, policy Policy) (time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key, now, policy.Window)
+
+	e.pending = max(e.pending-1, 0)
+	e.failures++
+	e.lastFailure = now
+
+	if delay := policy.delay(e.failures); delay > 0 {
+		e.lockedUntil = now.Add(delay)
+	}
+
+	return max(e.lockedUntil.Sub(now), 0), nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.pending = max(e.pending-1, 0)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
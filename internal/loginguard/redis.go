@@ -0,0 +1,142 @@
+package loginguard
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// pending returns the reservations of KEYS[1] that haven't timed out at now.
+const pendingLua = `
+local function pending(now)
+	local until_ms = tonumber(redis.call('HGET', KEYS[1], 'pending_until')) or 0
+	if now > until_ms then
+		return 0
+	end
+	return tonumber(redis.call('HGET', KEYS[1], 'pending')) or 0
+end
+`
+
+// attemptScript checks the lockout and reserves an attempt in one step, so
+// concurrent guesses from several replicas can't all get past the check
+// before the first failure is counted. Redis' own clock is used.
+//
+// KEYS[1] - key
+// ARGV[1] - free attempts
+// ARGV[2] - pending timeout in ms
+// ARGV[3] - pending retry in ms
+//
+// Returns how long to wait in ms, 0 when the attempt is reserved.
+var attemptScript = redis.NewScript(pendingLua + `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local free = tonumber(ARGV[1])
+local timeout = tonumber(ARGV[2])
+
+local locked_until = tonumber(redis.call('HGET', KEYS[1], 'locked_until')) or 0
+if locked_until > now then
+	return locked_until - now
+end
+
+local in_flight = pending(now)
+local failures = tonumber(redis.call('HGET', KEYS[1], 'failures')) or 0
+if in_flight > 0 and failures + in_flight > free then
+	return tonumber(ARGV[3])
+end
+
+redis.call('HSET', KEYS[1], 'pending', in_flight + 1, 'pending_until', now + timeout)
+if redis.call('PTTL', KEYS[1]) < timeout then
+	redis.call('PEXPIRE', KEYS[1], timeout)
+end
+
+return 0
+`)
+
+// failScript settles a reservation as a failure and sets the lockout in one
+// step, so concurrent guesses are all counted.
+//
+// KEYS[1] - key
+// ARGV[1] - free attempts
+// ARGV[2] - base delay in ms
+// ARGV[3] - max delay in ms
+// ARGV[4] - window in ms
+//
+// Returns the lockout left in ms.
+var failScript = redis.NewScript(pendingLua + `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local free = tonumber(ARGV[1])
+local base = tonumber(ARGV[2])
+local max_delay = tonumber(ARGV[3])
+local window = tonumber(ARGV[4])
+
+redis.call('HSET', KEYS[1], 'pending', math.max(pending(now) - 1, 0))
+
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+local locked_until = tonumber(redis.call('HGET', KEYS[1], 'locked_until')) or 0
+
+if failures > free then
+	local delay = math.floor(math.min(base * 2 ^ (failures - free - 1), max_delay))
+	locked_until = now + delay
+	redis.call('HSET', KEYS[1], 'locked_until', locked_until)
+end
+
+redis.call('PEXPIRE', KEYS[1], math.max(window, locked_until - now))
+
+return math.max(locked_until - now, 0)
+`)
+
+// releaseScript gives back a reservation of KEYS[1].
+var releaseScript = redis.NewScript(`
+local pending = tonumber(redis.call('HGET', KEYS[1], 'pending')) or 0
+if pending > 0 then
+	redis.call('HSET', KEYS[1], 'pending', pending - 1)
+end
+return 0
+`)
+
+// RedisStore shares the counters between every replica talking to the same Redis.
+type RedisStore struct {
+	rdb    redis.Cmdable
+	prefix string
+}
+
+func NewRedisStore(rdb redis.Cmdable) *RedisStore {
+	return &RedisStore{rdb: rdb, prefix: "loginguard:"}
+}
+
+func (s *RedisStore) Attempt(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	ms, err := attemptScript.Run(ctx, s.rdb, []string{s.prefix + key},
+		policy.FreeAttempts,
+		pendingTimeout.Milliseconds(),
+		pendingRetry.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (s *RedisStore) Fail(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	ms, err := failScript.Run(ctx, s.rdb, []string{s.prefix + key},
+		policy.FreeAttempts,
+		policy.BaseDelay.Milliseconds(),
+		policy.MaxDelay.Milliseconds(),
+		policy.Window.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return releaseScript.Run(ctx, s.rdb, []string{s.prefix + key}).Err()
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	return s.rdb.Del(ctx, s.prefix+key).Err()
+}
@@ -5,43 +5,70 @@ import (
 	"time"
 )
 
+type fixedWindow struct {
+	start time.Time
+	count int
+}
+
 type FixedWindowRateLimiter struct {
-	sync.RWMutex
-	clients map[string]int // In memory implementation
+	sync.Mutex
+	clients map[string]*fixedWindow // In memory implementation
 	limit   int
 	window  time.Duration
+	janitor *janitor
 }
 
 func NewFixedWindowLimiter(limit int, window time.Duration) *FixedWindowRateLimiter {
-	return &FixedWindowRateLimiter{
-		clients: make(map[string]int),
+	rl := &FixedWindowRateLimiter{
+		clients: make(map[string]*fixedWindow),
 		limit:   limit,
 		window:  window,
 	}
+	rl.janitor = startJanitor(window, rl.sweep)
+
+	return rl
 }
 
-func (rl *FixedWindowRateLimiter) Allow(ip string) (bool, time.Duration) {
-	rl.RLock()
-	count, exists := rl.clients[ip]
-	rl.RUnlock()
+func (rl *FixedWindowRateLimiter) Allow(ip string) Quota {
+	rl.Lock()
+	defer rl.Unlock()
 
-	if !exists || count < rl.limit {
-		rl.Lock()
-		if !exists {
-			go rl.resetCount(ip)
-		}
+	now := time.Now()
+
+	w, exists := rl.clients[ip]
+	if !exists || now.Sub(w.start) >= rl.window {
+		w = &fixedWindow{start: now}
+		rl.clients[ip] = w
+	}
 
-		rl.clients[ip]++
-		rl.Unlock()
-		return true, 0
+	quota := Quota{
+		Limit:      rl.limit,
+		ResetAfter: w.start.Add(rl.window).Sub(now),
 	}
 
-	return false, rl.window
+	if w.count < rl.limit {
+		w.count++
+		quota.Allowed = true
+		quota.Remaining = rl.limit - w.count
+		return quota
+	}
+
+	quota.RetryAfter = quota.ResetAfter
+	return quota
+}
+
+// Stop ends the background cleanup.
+func (rl *FixedWindowRateLimiter) Stop() {
+	rl.janitor.Stop()
 }
 
-func (rl *FixedWindowRateLimiter) resetCount(ip string) {
-	time.Sleep(rl.window)
+func (rl *FixedWindowRateLimiter) sweep(now time.Time) {
 	rl.Lock()
-	delete(rl.clients, ip)
-	rl.Unlock()
+	defer rl.Unlock()
+
+	for ip, w := range rl.clients {
+		if now.Sub(w.start) >= rl.window {
+			delete(rl.clients, ip)
+		}
+	}
 }
@@ -0,0 +1,37 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor is the single background goroutine an in-memory limiter uses to
+// forget idle clients, instead of one timer goroutine per client.
+type janitor struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func startJanitor(interval time.Duration, sweep func(now time.Time)) *janitor {
+	j := &janitor{stop: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				sweep(now)
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+
+	return j
+}
+
+func (j *janitor) Stop() {
+	j.once.Do(func() { close(j.stop) })
+}
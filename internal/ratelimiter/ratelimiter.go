@@ -1,13 +1,90 @@
 package ratelimiter
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Quota is a client's allowance as it stands after a call to Allow.
+type Quota struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration // Until the full limit is available again
+	RetryAfter time.Duration // Until the next request would be allowed, zero when Allowed
+}
 
 type Limiter interface {
-	Allow(ip string) (bool, time.Duration)
+	Allow(key string) Quota
+}
+
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// In-memory algorithms
+const (
+	AlgorithmFixedWindow   = "fixed-window"
+	AlgorithmSlidingWindow = "sliding-window"
+	AlgorithmTokenBucket   = "token-bucket"
+)
+
+// Policy is one named limit, e.g. a strict one for login attempts.
+type Policy struct {
+	RequestsPerTimeFrame int
+	TimeFrame            time.Duration
 }
 
 type Config struct {
 	RequestsPerTimeFrame int
 	TimeFrame            time.Duration
 	Enabled              bool
+	Backend              string            // BackendMemory or BackendRedis
+	Algorithm            string            // Used by the in-memory backend
+	Policies             map[string]Policy // Per route group overrides of the default policy above
+}
+
+// New builds the limiter for the named policy. With the Redis backend and a
+// client the limit is shared through Redis, falling back to the in-memory
+// algorithm when it can't be reached. Without a client, i.e. when Redis is
+// disabled, the in-memory limiter is used on its own.
+func New(c Config, name string, rdb redis.Scripter) (Limiter, error) {
+	policy := c.Policy(name)
+	if policy.RequestsPerTimeFrame <= 0 || policy.TimeFrame <= 0 {
+		return nil, fmt.Errorf("rate limiter policy %q needs a positive request count and time frame, got %d per %v",
+			name, policy.RequestsPerTimeFrame, policy.TimeFrame)
+	}
+
+	var limiter Limiter
+	switch c.Algorithm {
+	case AlgorithmFixedWindow:
+		limiter = NewFixedWindowLimiter(policy.RequestsPerTimeFrame, policy.TimeFrame)
+	case AlgorithmSlidingWindow:
+		limiter = NewSlidingWindowLimiter(policy.RequestsPerTimeFrame, policy.TimeFrame)
+	case AlgorithmTokenBucket:
+		limiter = NewTokenBucketLimiter(policy.RequestsPerTimeFrame, policy.TimeFrame)
+	default:
+		return nil, fmt.Errorf("unknown rate limiter algorithm %q", c.Algorithm)
+	}
+
+	if c.Backend == BackendRedis && rdb != nil {
+		limiter = NewRedisLimiter(rdb, name, policy.RequestsPerTimeFrame, policy.TimeFrame, limiter)
+	}
+
+	return limiter, nil
+}
+
+// Policy returns the named policy, or the default one when there is no such override.
+func (c Config) Policy(name string) Policy {
+	if p, ok := c.Policies[name]; ok {
+		return p
+	}
+
+	return Policy{
+		RequestsPerTimeFrame: c.RequestsPerTimeFrame,
+		TimeFrame:            c.TimeFrame,
+	}
 }
@@ -0,0 +1,223 @@
+package ratelimiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestRedis starts an in-process Redis whose clock only moves when the test says so.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	mr.SetTime(testStart)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	return mr, rdb
+}
+
+// countingLimiter allows everything and counts how often it was asked.
+type countingLimiter struct {
+	calls atomic.Int64
+}
+
+func (l *countingLimiter) Allow(string) Quota {
+	l.calls.Add(1)
+	return Quota{Allowed: true, Limit: -1}
+}
+
+func TestRedisLimiterAllowsBurstThenDenies(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	fallback := &countingLimiter{}
+	rl := NewRedisLimiter(rdb, "test", 3, 3*time.Second, fallback)
+
+	for i, remaining := range []int{2, 1, 0} {
+		q := rl.Allow("1.2.3.4")
+		if !q.Allowed {
+			t.Fatalf("request %d denied, want allowed", i+1)
+		}
+		if q.Remaining != remaining {
+			t.Errorf("request %d: remaining = %d, want %d", i+1, q.Remaining, remaining)
+		}
+		if q.RetryAfter != 0 {
+			t.Errorf("request %d: retry after = %v, want 0", i+1, q.RetryAfter)
+		}
+		if q.Limit != 3 {
+			t.Errorf("request %d: limit = %d, want 3", i+1, q.Limit)
+		}
+	}
+
+	q := rl.Allow("1.2.3.4")
+	if q.Allowed {
+		t.Fatal("request over the limit allowed")
+	}
+	// One request is freed every window/limit
+	if q.RetryAfter != time.Second {
+		t.Errorf("retry after = %v, want 1s", q.RetryAfter)
+	}
+	if q.ResetAfter != 3*time.Second {
+		t.Errorf("reset after = %v, want 3s", q.ResetAfter)
+	}
+
+	// Denied requests don't use up the allowance
+	mr.SetTime(testStart.Add(time.Second))
+	if q := rl.Allow("1.2.3.4"); !q.Allowed {
+		t.Fatalf("request after retry after denied, retry after = %v", q.RetryAfter)
+	}
+	if q := rl.Allow("1.2.3.4"); q.Allowed || q.RetryAfter != time.Second {
+		t.Errorf("second request after retry after: allowed = %v, retry after = %v, want denied for 1s", q.Allowed, q.RetryAfter)
+	}
+
+	// Other keys have their own allowance
+	if q := rl.Allow("5.6.7.8"); !q.Allowed || q.Remaining != 2 {
+		t.Errorf("other key: allowed = %v, remaining = %d, want allowed with 2 remaining", q.Allowed, q.Remaining)
+	}
+
+	if n := fallback.calls.Load(); n != 0 {
+		t.Errorf("fallback used %d times while redis was up", n)
+	}
+}
+
+func TestRedisLimiterRecoversAfterWindow(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	rl := NewRedisLimiter(rdb, "test", 2, time.Minute, &countingLimiter{})
+
+	rl.Allow("key")
+	rl.Allow("key")
+	if q := rl.Allow("key"); q.Allowed {
+		t.Fatal("request over the limit allowed")
+	}
+
+	mr.SetTime(testStart.Add(time.Minute))
+	mr.FastForward(time.Minute)
+
+	if q := rl.Allow("key"); !q.Allowed || q.Remaining != 1 {
+		t.Errorf("after a window: allowed = %v, remaining = %d, want allowed with 1 remaining", q.Allowed, q.Remaining)
+	}
+}
+
+func TestRedisLimiterConcurrentCallersShareKey(t *testing.T) {
+	_, rdb := newTestRedis(t)
+
+	const limit = 10
+
+	// Two limiters stand in for two replicas sharing one Redis
+	replicas := []*RedisRateLimiter{
+		NewRedisLimiter(rdb, "test", limit, time.Minute, &countingLimiter{}),
+		NewRedisLimiter(rdb, "test", limit, time.Minute, &countingLimiter{}),
+	}
+	for _, rl := range replicas {
+		rl.timeout = 5 * time.Second
+	}
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if replicas[i%len(replicas)].Allow("shared").Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := allowed.Load(); n != limit {
+		t.Errorf("%d concurrent requests allowed, want %d", n, limit)
+	}
+}
+
+func TestRedisLimiterFallsBackWhenRedisIsDown(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	fallback := &countingLimiter{}
+	rl := NewRedisLimiter(rdb, "test", 1, time.Minute, fallback)
+
+	mr.Close()
+
+	for range 3 {
+		if q := rl.Allow("key"); !q.Allowed || q.Limit != -1 {
+			t.Fatalf("quota = %+v, want the fallback's", q)
+		}
+	}
+	if n := fallback.calls.Load(); n != 3 {
+		t.Errorf("fallback used %d times, want 3", n)
+	}
+}
+
+func TestNewRejectsEmptyPolicies(t *testing.T) {
+	_, rdb := newTestRedis(t)
+
+	for name, cfg := range map[string]Config{
+		"no requests":       {RequestsPerTimeFrame: 0, TimeFrame: time.Minute},
+		"negative requests": {RequestsPerTimeFrame: -1, TimeFrame: time.Minute},
+		"no time frame":     {RequestsPerTimeFrame: 10, TimeFrame: 0},
+		"override":          {RequestsPerTimeFrame: 10, TimeFrame: time.Minute, Policies: map[string]Policy{"test": {RequestsPerTimeFrame: 0, TimeFrame: time.Minute}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Backend = BackendRedis
+			cfg.Algorithm = AlgorithmFixedWindow
+
+			if limiter, err := New(cfg, "test", rdb); err == nil {
+				t.Errorf("got %T, want an error", limiter)
+			}
+		})
+	}
+}
+
+func TestNewSelectsBackend(t *testing.T) {
+	_, rdb := newTestRedis(t)
+
+	cfg := Config{
+		RequestsPerTimeFrame: 1,
+		TimeFrame:            time.Minute,
+		Backend:              BackendRedis,
+		Algorithm:            AlgorithmFixedWindow,
+	}
+
+	limiter, err := New(cfg, "test", rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, ok := limiter.(*RedisRateLimiter)
+	if !ok {
+		t.Fatalf("limiter is %T, want *RedisRateLimiter", limiter)
+	}
+	if fw, ok := rl.fallback.(*FixedWindowRateLimiter); !ok {
+		t.Errorf("fallback is %T, want *FixedWindowRateLimiter", rl.fallback)
+	} else {
+		fw.Stop()
+	}
+
+	// Redis disabled: the in-memory limiter enforces the limit on its own
+	limiter, err = New(cfg, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, ok := limiter.(*FixedWindowRateLimiter)
+	if !ok {
+		t.Fatalf("limiter is %T, want *FixedWindowRateLimiter", limiter)
+	}
+	defer fw.Stop()
+
+	if !fw.Allow("key").Allowed {
+		t.Error("first request denied")
+	}
+	if fw.Allow("key").Allowed {
+		t.Error("request over the limit allowed")
+	}
+
+	cfg.Algorithm = "leaky-bucket"
+	if _, err := New(cfg, "test", nil); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+type slidingWindow struct {
+	start    time.Time // Start of the current window
+	current  int
+	previous int
+}
+
+// SlidingWindowRateLimiter approximates a true sliding window by weighting the
+// previous window's count by how much of it still overlaps the last window duration.
+// It needs two counters per client instead of a log of timestamps.
+type SlidingWindowRateLimiter struct {
+	sync.Mutex
+	clients map[string]*slidingWindow
+	limit   int
+	window  time.Duration
+	janitor *janitor
+}
+
+func NewSlidingWindowLimiter(limit int, window time.Duration) *SlidingWindowRateLimiter {
+	rl := &SlidingWindowRateLimiter{
+		clients: make(map[string]*slidingWindow),
+		limit:   limit,
+		window:  window,
+	}
+	rl.janitor = startJanitor(window, rl.sweep)
+
+	return rl
+}
+
+func (rl *SlidingWindowRateLimiter) Allow(ip string) Quota {
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+
+	w, exists := rl.clients[ip]
+	if !exists {
+		w = &slidingWindow{start: now.Truncate(rl.window)}
+		rl.clients[ip] = w
+	}
+	rl.advance(w, now)
+
+	elapsed := now.Sub(w.start)
+	quota := Quota{Limit: rl.limit}
+
+	if rl.estimate(w, elapsed)+1 <= float64(rl.limit) {
+		w.current++
+		quota.Allowed = true
+	} else {
+		quota.RetryAfter = rl.retryAfter(w, elapsed)
+	}
+
+	quota.Remaining = max(0, int(float64(rl.limit)-rl.estimate(w, elapsed)))
+	quota.ResetAfter = rl.resetAfter(w, elapsed)
+
+	return quota
+}
+
+// advance rolls the counters forward to the window containing now.
+func (rl *SlidingWindowRateLimiter) advance(w *slidingWindow, now time.Time) {
+	start := now.Truncate(rl.window)
+
+	switch start.Sub(w.start) {
+	case 0:
+	case rl.window:
+		w.previous, w.current = w.current, 0
+	default:
+		w.previous, w.current = 0, 0
+	}
+	w.start = start
+}
+
+func (rl *SlidingWindowRateLimiter) estimate(w *slidingWindow, elapsed time.Duration) float64 {
+	overlap := float64(rl.window-elapsed) / float64(rl.window)
+	return float64(w.previous)*overlap + float64(w.current)
+}
+
+// retryAfter works out when the estimate drops far enough for one more request.
+func (rl *SlidingWindowRateLimiter) retryAfter(w *slidingWindow, elapsed time.Duration) time.Duration {
+	free := float64(rl.limit - 1)
+	window := float64(rl.window)
+
+	// Still room in this window once enough of the previous one has slid out
+	if w.current <= rl.limit-1 && w.previous > 0 {
+		at := window * (1 - (free-float64(w.current))/float64(w.previous))
+		return time.Duration(at) - elapsed
+	}
+
+	// Otherwise wait for the next window, where the current count becomes the weighted one
+	wait := rl.window - elapsed
+	if w.current > 0 {
+		wait += time.Duration(max(0, window*(1-free/float64(w.current))))
+	}
+
+	return wait
+}
+
+// resetAfter is how long until every request counted so far has slid out of the window.
+func (rl *SlidingWindowRateLimiter) resetAfter(w *slidingWindow, elapsed time.Duration) time.Duration {
+	switch {
+	case w.current > 0:
+		return 2*rl.window - elapsed
+	case w.previous > 0:
+		return rl.window - elapsed
+	default:
+		return 0
+	}
+}
+
+// Stop ends the background cleanup.
+func (rl *SlidingWindowRateLimiter) Stop() {
+	rl.janitor.Stop()
+}
+
+// sweep drops clients with no requests in the last two windows.
+func (rl *SlidingWindowRateLimiter) sweep(now time.Time) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	for ip, w := range rl.clients {
+		if now.Sub(w.start) >= 2*rl.window {
+			delete(rl.clients, ip)
+		}
+	}
+}
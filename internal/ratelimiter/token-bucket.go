@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucketRateLimiter lets a client burst up to limit requests, then refills
+// at limit/window tokens per second. Unlike a fixed window it never lets through
+// more than the limit around window boundaries.
+type TokenBucketRateLimiter struct {
+	sync.Mutex
+	clients map[string]*bucket
+	limit   int
+	rate    float64 // tokens per second
+	janitor *janitor
+}
+
+func NewTokenBucketLimiter(limit int, window time.Duration) *TokenBucketRateLimiter {
+	rl := &TokenBucketRateLimiter{
+		clients: make(map[string]*bucket),
+		limit:   limit,
+		rate:    float64(limit) / window.Seconds(),
+	}
+	rl.janitor = startJanitor(window, rl.sweep)
+
+	return rl
+}
+
+func (rl *TokenBucketRateLimiter) Allow(ip string) Quota {
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+
+	b, exists := rl.clients[ip]
+	if !exists {
+		b = &bucket{tokens: float64(rl.limit), last: now}
+		rl.clients[ip] = b
+	}
+
+	b.tokens = math.Min(float64(rl.limit), b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	quota := Quota{Limit: rl.limit}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		quota.Allowed = true
+	} else {
+		quota.RetryAfter = secondsToDuration((1 - b.tokens) / rl.rate)
+	}
+
+	quota.Remaining = int(b.tokens)
+	quota.ResetAfter = secondsToDuration((float64(rl.limit) - b.tokens) / rl.rate)
+
+	return quota
+}
+
+// Stop ends the background cleanup.
+func (rl *TokenBucketRateLimiter) Stop() {
+	rl.janitor.Stop()
+}
+
+// sweep drops buckets that have refilled completely, they are
+// indistinguishable from a client we have never seen.
+func (rl *TokenBucketRateLimiter) sweep(now time.Time) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	for ip, b := range rl.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= float64(rl.limit) {
+			delete(rl.clients, ip)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
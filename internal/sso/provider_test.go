@@ -0,0 +1,179 @@
+package sso_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"Url-Shortener/internal/sso"
+	"Url-Shortener/internal/sso/ssotest"
+
+	"golang.org/x/oauth2"
+)
+
+func newTestProvider(t *testing.T) (*sso.Provider, *ssotest.Issuer) {
+	t.Helper()
+
+	issuer, err := ssotest.NewIssuer("shortener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	provider := sso.NewProvider(sso.ProviderConfig{
+		Name:         "test",
+		Issuer:       issuer.URL,
+		ClientID:     "shortener",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/api/v1/auth/oidc/test/callback",
+		Scopes:       []string{"email", "profile"},
+	})
+
+	return provider, issuer
+}
+
+// signIn runs the flow up to the callback and redeems the code with verifier.
+func signIn(t *testing.T, login ssotest.Login, verifier func(sent string) string) (*sso.Identity, error) {
+	t.Helper()
+
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	sentVerifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", sentVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state, err := issuer.Authorize(authURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state = %q, want state-1", state)
+	}
+
+	return provider.Exchange(ctx, code, "nonce-1", verifier(sentVerifier))
+}
+
+func sameVerifier(sent string) string { return sent }
+
+func TestAuthCodeURL(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, issuer.URL+"/authorize?") {
+		t.Errorf("auth URL %q doesn't point to the discovered endpoint", authURL)
+	}
+
+	q := u.Query()
+	for param, want := range map[string]string{
+		"client_id":             "shortener",
+		"response_type":         "code",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge_method": "S256",
+		"scope":                 "openid email profile",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+	if q.Get("code_challenge") == "" {
+		t.Error("no PKCE challenge")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	identity, err := signIn(t, ssotest.Login{
+		Subject:       "user-1",
+		Email:         "ada@example.com",
+		EmailVerified: "true", // Some providers send strings
+		Name:          "Ada Lovelace",
+		Username:      "ada",
+	}, sameVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sso.Identity{
+		Subject:       "user-1",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Name:          "Ada Lovelace",
+		Username:      "ada",
+	}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeUnverifiedEmail(t *testing.T) {
+	for name, verified := range map[string]any{"false": false, "missing": nil} {
+		t.Run(name, func(t *testing.T) {
+			identity, err := signIn(t, ssotest.Login{Subject: "user-1", Email: "ada@example.com", EmailVerified: verified}, sameVerifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.EmailVerified {
+				t.Error("email reported as verified")
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsWrongPKCEVerifier(t *testing.T) {
+	_, err := signIn(t, ssotest.Login{Subject: "user-1"}, func(string) string {
+		return oauth2.GenerateVerifier()
+	})
+	if err == nil || !strings.Contains(err.Error(), "exchanging code") {
+		t.Errorf("err = %v, want a failed code exchange", err)
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	_, err := signIn(t, ssotest.Login{Subject: "user-1", Nonce: "replayed"}, sameVerifier)
+	if !errors.Is(err, sso.ErrNonceMismatch) {
+		t.Errorf("err = %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestExchangeRejectsBadSignature(t *testing.T) {
+	_, err := signIn(t, ssotest.Login{Subject: "user-1", BadSignature: true}, sameVerifier)
+	if err == nil || !strings.Contains(err.Error(), "verifying id token") {
+		t.Errorf("err = %v, want a failed ID token verification", err)
+	}
+}
+
+func TestExchangeRejectsReusedCode(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := issuer.Authorize(authURL, ssotest.Login{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Exchange(ctx, code, "nonce-1", verifier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(ctx, code, "nonce-1", verifier); err == nil {
+		t.Error("code redeemed twice")
+	}
+}
@@ -0,0 +1,219 @@
+// Package ssotest provides an OpenID Connect provider for tests, serving
+// discovery, keys and a token endpoint from an httptest.Server.
+package ssotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "ssotest"
+
+// Login is the user signing in at the issuer and what their ID token says.
+type Login struct {
+	Subject       string
+	Email         string
+	EmailVerified any // true, "true" or the like; nil leaves the claim out
+	Name          string
+	Username      string
+
+	Nonce        string // Replaces the nonce of the authorization request when set
+	BadSignature bool   // Signs the ID token with a key the issuer doesn't publish
+}
+
+type grant struct {
+	login     Login
+	nonce     string
+	challenge string
+}
+
+// Issuer is an OpenID Connect provider that lets anyone sign in as anyone. The
+// token endpoint checks the PKCE verifier the way a real provider would.
+type Issuer struct {
+	*httptest.Server
+	ClientID string
+
+	key   *rsa.PrivateKey
+	other *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// NewIssuer starts an issuer for the client, Close it when done.
+func NewIssuer(clientID string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Issuer{
+		ClientID: clientID,
+		key:      key,
+		other:    other,
+		grants:   make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("GET /keys", i.keys)
+	mux.HandleFunc("POST /token", i.token)
+	i.Server = httptest.NewServer(mux)
+
+	return i, nil
+}
+
+// Authorize stands in for the user signing in at authURL, as returned by
+// sso.Provider.AuthCodeURL. It returns the code and state the issuer would
+// send back to the callback.
+func (i *Issuer) Authorize(authURL string, login Login) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+
+	if q.Get("client_id") != i.ClientID {
+		return "", "", errors.New("ssotest: unknown client")
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		return "", "", errors.New("ssotest: authorization request without a PKCE challenge")
+	}
+
+	code = rand.Text()
+
+	i.mu.Lock()
+	i.grants[code] = grant{login: login, nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	i.mu.Unlock()
+
+	return code, q.Get("state"), nil
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) keys(w http.ResponseWriter, r *http.Request) {
+	pub := i.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	// Codes can only be redeemed once
+	i.mu.Lock()
+	g, ok := i.grants[code]
+	delete(i.grants, code)
+	i.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	idToken, err := i.idToken(g)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (i *Issuer) idToken(g grant) (string, error) {
+	now := time.Now()
+
+	nonce := g.nonce
+	if g.login.Nonce != "" {
+		nonce = g.login.Nonce
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   i.URL,
+		"aud":   i.ClientID,
+		"sub":   g.login.Subject,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	if g.login.Email != "" {
+		claims["email"] = g.login.Email
+	}
+	if g.login.EmailVerified != nil {
+		claims["email_verified"] = g.login.EmailVerified
+	}
+	if g.login.Name != "" {
+		claims["name"] = g.login.Name
+	}
+	if g.login.Username != "" {
+		claims["preferred_username"] = g.login.Username
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	key := i.key
+	if g.login.BadSignature {
+		key = i.other
+	}
+
+	return token.SignedString(key)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// apiKeyTouchInterval limits how often LastUsedAt is written for a busy key.
+const apiKeyTouchInterval = time.Minute
+
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"-"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // Identifies the key, e.g. "usk_1a2b3c4d5e6f_…"
+	KeyHash    string             `bson:"key_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+type APIKeyStore struct {
+	collection *mongo.Collection
+}
+
+func (s *APIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	key.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, key)
+	if err != nil {
+		if isDuplicateKeyError(err, "unique_prefix") {
+			return ErrConflict
+		}
+		return err
+	}
+
+	key.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (s *APIKeyStore) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var key APIKey
+	err := s.collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s *APIKeyStore) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []APIKey{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete revokes a key of the given user. Revoked keys are removed outright,
+// the prefix is all anyone would need them for and it is useless without the secret.
+func (s *APIKeyStore) Delete(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": keyID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *APIKeyStore) DeleteAllByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// Touch records that the key was used, at most once per apiKeyTouchInterval.
+func (s *APIKeyStore) Touch(ctx context.Context, keyID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{
+			"_id": keyID,
+			"$or": bson.A{
+				bson.M{"last_used_at": nil},
+				bson.M{"last_used_at": bson.M{"$lt": at.Add(-apiKeyTouchInterval)}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_used_at": at}},
+	)
+	return err
+}
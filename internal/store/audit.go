@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Audit actor types
+const (
+	ActorUser  = "user"  // Signed in with a token or API key
+	ActorAdmin = "admin" // Signed in with the admin basic auth credentials
+)
+
+// AuditEvent records one change: who did what to which resource, and how it
+// looked before and after. Events are only ever added, they go away when they expire.
+type AuditEvent struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	ActorType    string               `bson:"actor_type" json:"actor_type"`
+	ActorID      *primitive.ObjectID  `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	APIKeyID     *primitive.ObjectID  `bson:"api_key_id,omitempty" json:"api_key_id,omitempty"` // Set when the actor used an API key
+	Action       string               `bson:"action" json:"action"`                             // e.g. "url.delete"
+	TargetType   string               `bson:"target_type" json:"target_type"`
+	TargetID     string               `bson:"target_id" json:"target_id"`
+	WorkspaceIDs []primitive.ObjectID `bson:"workspace_ids,omitempty" json:"workspace_ids,omitempty"` // Workspaces whose admins can see the event
+	Before       map[string]any       `bson:"before,omitempty" json:"before,omitempty"`               // Changed fields only
+	After        map[string]any       `bson:"after,omitempty" json:"after,omitempty"`
+	RequestID    string               `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	IP           string               `bson:"ip" json:"ip"`
+	CreatedAt    time.Time            `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time            `bson:"expires_at" json:"-"`
+}
+
+// AuditQuery filters the events listed by List. Zero fields don't filter.
+type AuditQuery struct {
+	WorkspaceID *primitive.ObjectID
+	ActorID     *primitive.ObjectID
+	Action      string
+	TargetType  string
+	TargetID    string
+	From        *time.Time
+	To          *time.Time
+	Before      *primitive.ObjectID // Cursor, only events older than this one
+	Limit       int64
+}
+
+type AuditEventStore struct {
+	collection *mongo.Collection
+}
+
+// auditCollection decodes nested documents in Before and After as maps, so
+// they read back the way they were written instead of as key/value lists.
+func auditCollection(db *mongo.Database) *mongo.Collection {
+	return db.Collection("audit_events", options.Collection().SetBSONOptions(&options.BSONOptions{
+		DefaultDocumentM: true,
+	}))
+}
+
+// Create records the event, it is deleted once retention has passed.
+func (s *AuditEventStore) Create(ctx context.Context, event *AuditEvent, retention time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	event.CreatedAt = time.Now()
+	event.ExpiresAt = event.CreatedAt.Add(retention)
+
+	res, err := s.collection.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	event.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// CreateMany inserts the events in one batch.
+func (s *AuditEventStore) CreateMany(ctx context.Context, events []*AuditEvent, retention time.Duration) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	now := time.Now()
+
+	docs := make([]any, len(events))
+	for i, event := range events {
+		event.ID = primitive.NewObjectID()
+		event.CreatedAt = now
+		event.ExpiresAt = now.Add(retention)
+		docs[i] = event
+	}
+
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// List returns the events matching query, newest first. Pass the ID of the
+// last event as query.Before to get the next page.
+func (s *AuditEventStore) List(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	filter := bson.M{}
+	if query.WorkspaceID != nil {
+		filter["workspace_ids"] = query.WorkspaceID
+	}
+	if query.ActorID != nil {
+		filter["actor_id"] = query.ActorID
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	if query.TargetType != "" {
+		filter["target_type"] = query.TargetType
+	}
+	if query.TargetID != "" {
+		filter["target_id"] = query.TargetID
+	}
+	if query.Before != nil {
+		filter["_id"] = bson.M{"$lt": query.Before}
+	}
+
+	created := bson.M{}
+	if query.From != nil {
+		created["$gte"] = query.From
+	}
+	if query.To != nil {
+		created["$lt"] = query.To
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	// IDs grow with creation time, sorting on them keeps the cursor stable
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(query.Limit)
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevokedTokenStore is the Redis store.TokenDenylist, entries expire with the tokens they deny.
+type RevokedTokenStore struct {
+	rdb *redis.Client
+}
+
+func (s *RevokedTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	cacheKey := fmt.Sprintf("revoked-jti-%s", jti)
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	return s.rdb.SetEx(ctx, cacheKey, 1, ttl).Err()
+}
+
+func (s *RevokedTokenStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-jti-%s", jti)
+
+	n, err := s.rdb.Exists(ctx, cacheKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *RevokedTokenStore) RevokeUserTokens(ctx context.Context, userID primitive.ObjectID, before time.Time, ttl time.Duration) error {
+	cacheKey := fmt.Sprintf("revoked-user-%s", userID.Hex())
+	return s.rdb.SetEx(ctx, cacheKey, before.Unix(), ttl).Err()
+}
+
+func (s *RevokedTokenStore) UserTokensRevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	cacheKey := fmt.Sprintf("revoked-user-%s", userID.Hex())
+
+	data, err := s.rdb.Get(ctx, cacheKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(unix, 0), nil
+}
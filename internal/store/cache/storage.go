@@ -13,10 +13,20 @@ type Storage struct {
 		Set(context.Context, *store.User) error
 		Delete(context.Context, primitive.ObjectID)
 	}
+	Urls interface {
+		Get(context.Context, string) (*store.ShortURL, error)
+		Set(context.Context, *store.ShortURL) error
+		SetNotFound(context.Context, string) error
+		Delete(context.Context, string)
+		DeleteMany(context.Context, []string)
+	}
+	RevokedTokens store.TokenDenylist
 }
 
 func NewRedisStorage(rbd *redis.Client) Storage {
 	return Storage{
-		Users: &UserStore{rdb: rbd},
+		Users:         &UserStore{rdb: rbd},
+		Urls:          &UrlStore{rdb: rbd},
+		RevokedTokens: &RevokedTokenStore{rdb: rbd},
 	}
 }
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"Url-Shortener/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UrlStore struct {
+	rdb *redis.Client
+}
+
+const (
+	UrlExpTime         = 10 * time.Minute
+	UrlNotFoundExpTime = 30 * time.Second
+	// UrlTombstoneExpTime outlasts any lookup that may have read the link before it changed
+	UrlTombstoneExpTime = 30 * time.Second
+)
+
+// cachedURL holds just what a redirect needs. A NotFound entry remembers
+// that the short code doesn't exist, an Invalidated one that the link just
+// changed and must not be cached from a read that may predate the change.
+type cachedURL struct {
+	ID          primitive.ObjectID `json:"id"`
+	ShortCode   string             `json:"short_code"`
+	OriginalURL string             `json:"original_url"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty"`
+	Disabled    bool               `json:"disabled,omitempty"`
+	NotFound    bool               `json:"not_found,omitempty"`
+	Invalidated bool               `json:"invalidated,omitempty"`
+}
+
+// Get returns the cached link, nil on a cache miss, or store.ErrNotFound
+// when the short code is known not to exist.
+func (s *UrlStore) Get(ctx context.Context, shortCode string) (*store.ShortURL, error) {
+	cacheKey := fmt.Sprintf("url-%s", shortCode)
+
+	data, err := s.rdb.Get(ctx, cacheKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entry cachedURL
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, err
+	}
+
+	if entry.NotFound {
+		return nil, store.ErrNotFound
+	}
+	if entry.Invalidated {
+		return nil, nil
+	}
+
+	return &store.ShortURL{
+		ID:          entry.ID,
+		ShortCode:   entry.ShortCode,
+		OriginalURL: entry.OriginalURL,
+		ExpiresAt:   entry.ExpiresAt,
+		Disabled:    entry.Disabled,
+	}, nil
+}
+
+// Set caches the link unless the short code has an entry already. Entries are
+// only ever replaced by Delete, so a lookup racing an update can't bring back
+// what the update changed.
+func (s *UrlStore) Set(ctx context.Context, shortURL *store.ShortURL) error {
+	cacheKey := fmt.Sprintf("url-%s", shortURL.ShortCode)
+
+	jsonn, err := json.Marshal(cachedURL{
+		ID:          shortURL.ID,
+		ShortCode:   shortURL.ShortCode,
+		OriginalURL: shortURL.OriginalURL,
+		ExpiresAt:   shortURL.ExpiresAt,
+		Disabled:    shortURL.Disabled,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Don't outlive the link, once it expires the TTL sweep will remove it from Mongo
+	exp := UrlExpTime
+	if shortURL.ExpiresAt != nil {
+		if untilExpiry := time.Until(*shortURL.ExpiresAt); untilExpiry < exp {
+			exp = max(untilExpiry, UrlNotFoundExpTime)
+		}
+	}
+
+	return s.rdb.SetNX(ctx, cacheKey, jsonn, exp).Err()
+}
+
+// SetNotFound remembers the short code doesn't exist, unless it has an entry already.
+func (s *UrlStore) SetNotFound(ctx context.Context, shortCode string) error {
+	cacheKey := fmt.Sprintf("url-%s", shortCode)
+
+	jsonn, err := json.Marshal(cachedURL{ShortCode: shortCode, NotFound: true})
+	if err != nil {
+		return err
+	}
+
+	return s.rdb.SetNX(ctx, cacheKey, jsonn, UrlNotFoundExpTime).Err()
+}
+
+// Delete drops the cached link after it changed. It leaves a tombstone rather
+// than nothing, which keeps Set and SetNotFound from caching lookups that read
+// the link before the change.
+func (s *UrlStore) Delete(ctx context.Context, shortCode string) {
+	cacheKey := fmt.Sprintf("url-%s", shortCode)
+
+	jsonn, err := json.Marshal(cachedURL{ShortCode: shortCode, Invalidated: true})
+	if err != nil {
+		return
+	}
+
+	s.rdb.SetEx(ctx, cacheKey, jsonn, UrlTombstoneExpTime)
+}
+
+// DeleteMany is Delete for several links in one round trip.
+func (s *UrlStore) DeleteMany(ctx context.Context, shortCodes []string) {
+	if len(shortCodes) == 0 {
+		return
+	}
+
+	s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, shortCode := range shortCodes {
+			jsonn, err := json.Marshal(cachedURL{ShortCode: shortCode, Invalidated: true})
+			if err != nil {
+				return err
+			}
+			pipe.SetEx(ctx, fmt.Sprintf("url-%s", shortCode), jsonn, UrlTombstoneExpTime)
+		}
+		return nil
+	})
+}
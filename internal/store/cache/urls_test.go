@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"Url-Shortener/internal/store"
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestUrlStore(t *testing.T) (*miniredis.Miniredis, *UrlStore) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	return mr, &UrlStore{rdb: rdb}
+}
+
+func TestUrlDeleteManyLeavesTombstones(t *testing.T) {
+	mr, s := newTestUrlStore(t)
+	ctx := context.Background()
+
+	if err := s.Set(ctx, &store.ShortURL{ShortCode: "abc", OriginalURL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetNotFound(ctx, "spring"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DeleteMany(ctx, []string{"abc", "spring", "never-cached"})
+
+	for _, code := range []string{"abc", "spring", "never-cached"} {
+		if url, err := s.Get(ctx, code); url != nil || err != nil {
+			t.Errorf("%s: got %v, %v after DeleteMany, want a miss", code, url, err)
+		}
+		if ttl := mr.TTL("url-" + code); ttl != UrlTombstoneExpTime {
+			t.Errorf("%s: ttl = %v, want %v", code, ttl, UrlTombstoneExpTime)
+		}
+	}
+
+	// A lookup that read the link before it was created can't cache it as missing
+	if err := s.SetNotFound(ctx, "spring"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "spring"); err != nil {
+		t.Errorf("stale lookup cached over the tombstone: %v", err)
+	}
+
+	mr.FastForward(UrlTombstoneExpTime)
+	if err := s.Set(ctx, &store.ShortURL{ShortCode: "spring", OriginalURL: "https://example.com/spring"}); err != nil {
+		t.Fatal(err)
+	}
+	if url, err := s.Get(ctx, "spring"); err != nil || url == nil || url.OriginalURL != "https://example.com/spring" {
+		t.Errorf("after the tombstone: got %v, %v, want the link", url, err)
+	}
+}
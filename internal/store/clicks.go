@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Click is a single redirect through a short link, with the request metadata
+// captured at redirect time.
+type Click struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	URLID      primitive.ObjectID `bson:"url_id" json:"-"`
+	ShortCode  string             `bson:"short_code" json:"short_code"`
+	OccurredAt time.Time          `bson:"occurred_at" json:"occurred_at"`
+	Referrer   string             `bson:"referrer" json:"referrer"` // Referring host, "direct" when there is none
+	Browser    string             `bson:"browser" json:"browser"`
+	OS         string             `bson:"os" json:"os"`
+	Device     string             `bson:"device" json:"device"`
+	Country    string             `bson:"country" json:"country"` // ISO 3166-1 alpha-2, empty when unknown
+}
+
+type StatsQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval string // "hour", "day" or "week"
+	Top      int    // Max entries per breakdown
+}
+
+type ClickBucket struct {
+	Start time.Time `bson:"_id" json:"start"`
+	Count int64     `bson:"count" json:"count"`
+}
+
+type ClickBreakdown struct {
+	Value string `bson:"_id" json:"value"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+type ClickStats struct {
+	Total     int64            `json:"total"`
+	Interval  string           `json:"interval"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Timeline  []ClickBucket    `json:"timeline"`
+	Referrers []ClickBreakdown `json:"referrers"`
+	Browsers  []ClickBreakdown `json:"browsers"`
+	OS        []ClickBreakdown `json:"os"`
+	Devices   []ClickBreakdown `json:"devices"`
+	Countries []ClickBreakdown `json:"countries"`
+}
+
+type ClickStore struct {
+	collection *mongo.Collection
+}
+
+func (s *ClickStore) InsertMany(ctx context.Context, clicks []Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	docs := make([]any, len(clicks))
+	for i := range clicks {
+		docs[i] = clicks[i]
+	}
+
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// Stats aggregates the clicks of one link in [q.From, q.To) in a single pass.
+// DeleteByURLs removes the click events of the given links.
+func (s *ClickStore) DeleteByURLs(ctx context.Context, urlIDs []primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"url_id": bson.M{"$in": urlIDs}})
+	return err
+}
+
+func (s *ClickStore) Stats(ctx context.Context, urlID primitive.ObjectID, q StatsQuery) (*ClickStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	breakdown := func(field string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+			bson.M{"$limit": q.Top},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"url_id":      urlID,
+			"occurred_at": bson.M{"$gte": q.From, "$lt": q.To},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"timeline": bson.A{
+				bson.M{"$group": bson.M{
+					"_id": bson.M{"$dateTrunc": bson.M{
+						"date":        "$occurred_at",
+						"unit":        q.Interval,
+						"startOfWeek": "monday",
+					}},
+					"count": bson.M{"$sum": 1},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"referrers": breakdown("referrer"),
+			"browsers":  breakdown("browser"),
+			"os":        breakdown("os"),
+			"devices":   breakdown("device"),
+			"countries": breakdown("country"),
+		}}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total     []struct{ Count int64 } `bson:"total"`
+		Timeline  []ClickBucket           `bson:"timeline"`
+		Referrers []ClickBreakdown        `bson:"referrers"`
+		Browsers  []ClickBreakdown        `bson:"browsers"`
+		OS        []ClickBreakdown        `bson:"os"`
+		Devices   []ClickBreakdown        `bson:"devices"`
+		Countries []ClickBreakdown        `bson:"countries"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	stats := &ClickStats{
+		Interval:  q.Interval,
+		From:      q.From,
+		To:        q.To,
+		Timeline:  []ClickBucket{},
+		Referrers: []ClickBreakdown{},
+		Browsers:  []ClickBreakdown{},
+		OS:        []ClickBreakdown{},
+		Devices:   []ClickBreakdown{},
+		Countries: []ClickBreakdown{},
+	}
+	if len(result) == 0 {
+		return stats, nil
+	}
+
+	r := result[0]
+	if len(r.Total) > 0 {
+		stats.Total = r.Total[0].Count
+	}
+	stats.Timeline = append(stats.Timeline, r.Timeline...)
+	stats.Referrers = append(stats.Referrers, r.Referrers...)
+	stats.Browsers = append(stats.Browsers, r.Browsers...)
+	stats.OS = append(stats.OS, r.OS...)
+	stats.Devices = append(stats.Devices, r.Devices...)
+	stats.Countries = append(stats.Countries, r.Countries...)
+
+	return stats, nil
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"Url-Shortener/internal/base62"
+	"Url-Shortener/internal/idgen"
+	"context"
+)
+
+// CodeGenerator produces candidate short codes for new links.
+type CodeGenerator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Base62CodeGenerator encodes IDs from an idgen.Generator as base62 short codes.
+type Base62CodeGenerator struct {
+	ids idgen.Generator
+}
+
+func NewBase62CodeGenerator(ids idgen.Generator) *Base62CodeGenerator {
+	return &Base62CodeGenerator{ids: ids}
+}
+
+func (g *Base62CodeGenerator) Generate(ctx context.Context) (string, error) {
+	id, err := g.ids.NextID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return base62.Encode(id), nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type counter struct {
+	Name  string `bson:"_id"`
+	Value int64  `bson:"value"`
+}
+
+// CounterStore keeps named sequences in the "counters" collection and
+// implements idgen.RangeReserver on top of them.
+type CounterStore struct {
+	collection *mongo.Collection
+}
+
+func NewCounterStore(db *mongo.Database) *CounterStore {
+	return &CounterStore{db.Collection("counters")}
+}
+
+func (s *CounterStore) Reserve(ctx context.Context, name string, size int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// A single $inc with upsert is atomic, so concurrent callers always get disjoint ranges.
+	var c counter
+	err := s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"value": size}},
+		options.FindOneAndUpdate().
+			SetUpsert(true).
+			SetReturnDocument(options.After),
+	).Decode(&c)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Value, nil
+}
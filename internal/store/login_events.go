@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Login event outcomes
+const (
+	LoginSucceeded   = "succeeded"
+	LoginFailed      = "failed"
+	LoginLocked      = "locked" // Refused without checking the password
+	LoginMFARequired = "mfa_required"
+	LoginRefused     = "refused"  // Right password but the account is deactivated
+	LoginUnlocked    = "unlocked" // Lockout lifted by an admin
+)
+
+// LoginEvent records one login attempt. UserID is empty when the email isn't registered.
+type LoginEvent struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string              `bson:"email" json:"email"`
+	IP        string              `bson:"ip" json:"ip"`
+	UserAgent string              `bson:"user_agent" json:"user_agent"`
+	Outcome   string              `bson:"outcome" json:"outcome"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+}
+
+type LoginEventStore struct {
+	collection *mongo.Collection
+}
+
+func (s *LoginEventStore) Create(ctx context.Context, event *LoginEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	event.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	event.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// ListByUser returns the user's most recent login events first.
+func (s *LoginEventStore) ListByUser(ctx context.Context, userID primitive.ObjectID, limit int64) ([]LoginEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []LoginEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// RecentIPs returns the addresses logins to the user came from since the given time,
+// including the refused ones that are only recorded with the email.
+func (s *LoginEventStore) RecentIPs(ctx context.Context, userID primitive.ObjectID, email string, since time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	filter := bson.M{
+		"$or":        bson.A{bson.M{"user_id": userID}, bson.M{"email": email}},
+		"created_at": bson.M{"$gte": since},
+	}
+
+	values, err := s.collection.Distinct(ctx, "ip", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(values))
+	for _, value := range values {
+		if ip, ok := value.(string); ok && ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrDuplicateIdentity = errors.New("external identity is already linked to a user")
+
+// OIDCState is what we keep between redirecting to a provider and its callback.
+type OIDCState struct {
+	State     string    `bson:"_id"`
+	Provider  string    `bson:"provider"`
+	Nonce     string    `bson:"nonce"`
+	Verifier  string    `bson:"verifier"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type OIDCStateStore struct {
+	collection *mongo.Collection
+}
+
+func (s *OIDCStateStore) Create(ctx context.Context, state *OIDCState) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	state.CreatedAt = time.Now()
+
+	_, err := s.collection.InsertOne(ctx, state)
+	return err
+}
+
+// Consume returns the state and deletes it so it can't be replayed.
+// Expired states are treated as missing, the TTL index may not have removed them yet.
+func (s *OIDCStateStore) Consume(ctx context.Context, provider, state string) (*OIDCState, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var stored OIDCState
+	err := s.collection.FindOneAndDelete(ctx, bson.M{
+		"_id":        state,
+		"provider":   provider,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&stored)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// Identity links an account at an external provider to a local user.
+type Identity struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Subject   string             `bson:"subject" json:"subject"`
+	Email     string             `bson:"email" json:"email"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type IdentityStore struct {
+	collection *mongo.Collection
+}
+
+func (s *IdentityStore) Create(ctx context.Context, identity *Identity) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	identity.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, identity)
+	if err != nil {
+		if isDuplicateKeyError(err, "unique_provider_subject") {
+			return ErrDuplicateIdentity
+		}
+		return err
+	}
+
+	identity.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (s *IdentityStore) Get(ctx context.Context, provider, subject string) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var identity Identity
+	err := s.collection.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&identity)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (s *IdentityStore) DeleteAllByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
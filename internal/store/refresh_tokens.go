@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrTokenReused = errors.New("refresh token was already used")
+
+// RefreshToken is one link in a rotation chain. Every login starts a new family,
+// and each refresh revokes the presented token and issues the next one in the same family.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	FamilyID  primitive.ObjectID `bson:"family_id"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty"`
+	AuthTime  time.Time          `bson:"auth_time,omitempty"` // When the user signed in, carried along the family
+}
+
+type RefreshTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *RefreshTokenStore) Create(ctx context.Context, token *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	token.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (s *RefreshTokenStore) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var token RefreshToken
+	err := s.collection.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Rotate revokes old and stores next in its place. If old was revoked in the
+// meantime (a concurrent refresh or a replayed token) it returns ErrTokenReused.
+func (s *RefreshTokenStore) Rotate(ctx context.Context, old, next *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": old.ID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTokenReused
+	}
+
+	next.FamilyID = old.FamilyID
+	next.UserID = old.UserID
+
+	return s.Create(ctx, next)
+}
+
+// RevokeFamily ends the session a token belongs to.
+func (s *RefreshTokenStore) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeAllForUser ends every session of the user.
+func (s *RefreshTokenStore) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
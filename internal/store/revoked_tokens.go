@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenDenylist keeps track of access tokens revoked before they expire. It is
+// implemented here on Mongo and in the cache package on Redis.
+type TokenDenylist interface {
+	// RevokeToken denies the token with the given "jti" until it expires.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeUserTokens denies every token of the user issued before the given time.
+	// Entries are kept for ttl, which must be at least the access token lifetime.
+	RevokeUserTokens(ctx context.Context, userID primitive.ObjectID, before time.Time, ttl time.Duration) error
+	// UserTokensRevokedBefore returns the cut-off set by RevokeUserTokens, zero if none.
+	UserTokensRevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error)
+}
+
+type revokedToken struct {
+	Key       string    `bson:"_id"`
+	Before    time.Time `bson:"before,omitempty"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// RevokedTokenStore is the Mongo TokenDenylist. Expired entries are removed by a TTL index.
+type RevokedTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *RevokedTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.M{"_id": "jti:" + jti},
+		revokedToken{Key: "jti:" + jti, ExpiresAt: expiresAt},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *RevokedTokenStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// The TTL monitor lags behind, so the expiry is checked here as well
+	count, err := s.collection.CountDocuments(ctx, bson.M{
+		"_id":        "jti:" + jti,
+		"expires_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s *RevokedTokenStore) RevokeUserTokens(ctx context.Context, userID primitive.ObjectID, before time.Time, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	key := "user:" + userID.Hex()
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.M{"_id": key},
+		revokedToken{Key: key, Before: before, ExpiresAt: time.Now().Add(ttl)},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *RevokedTokenStore) UserTokensRevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var entry revokedToken
+	err := s.collection.FindOne(ctx, bson.M{"_id": "user:" + userID.Hex()}).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return entry.Before, nil
+}
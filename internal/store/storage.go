@@ -5,32 +5,158 @@ import (
 	"errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
 	"time"
 )
 
 var (
 	ErrNotFound          = errors.New("resource not found")
 	ErrConflict          = errors.New("resource already exists")
+	ErrEditConflict      = errors.New("resource was modified concurrently, reload and try again")
 	QueryTimeoutDuration = time.Second * 5
 )
 
 type Storage struct {
 	Urls interface {
 		Create(context.Context, *ShortURL) error
+		CreateMany(context.Context, []*ShortURL) []error
 		GetByShortCode(context.Context, string) (*ShortURL, error)
-		GetAllUrlsByUser(context.Context, primitive.ObjectID) ([]ShortURL, error)
+		ListPage(context.Context, UrlPageQuery) ([]ShortURL, string, error)
+		Update(context.Context, *ShortURL) error
+		IncrementVisits(context.Context, map[primitive.ObjectID]uint64) error
 		Delete(context.Context, string) error
+		DeleteAllByUser(context.Context, primitive.ObjectID) ([]ShortURL, error)
+		DeleteAllByWorkspace(context.Context, primitive.ObjectID) ([]ShortURL, error)
+		TransferOwner(ctx context.Context, id primitive.ObjectID, from, to LinkOwner) (*ShortURL, error)
+		List(context.Context, UrlQuery) ([]ShortURL, error)
+	}
+	Clicks interface {
+		InsertMany(context.Context, []Click) error
+		DeleteByURLs(context.Context, []primitive.ObjectID) error
+		Stats(context.Context, primitive.ObjectID, StatsQuery) (*ClickStats, error)
 	}
 	Users interface {
 		Create(context.Context, *User) error
 		GetById(context.Context, primitive.ObjectID) (*User, error)
 		GetByEmail(context.Context, string) (*User, error)
+		Update(context.Context, *User) error
+		Delete(context.Context, primitive.ObjectID) error
+		List(context.Context, UserQuery) ([]User, error)
+		SetActive(ctx context.Context, id primitive.ObjectID, active bool) error
+		SetRole(ctx context.Context, id primitive.ObjectID, role string) error
+		SetEmailVerified(context.Context, primitive.ObjectID) error
+		UpdatePassword(context.Context, *User) error
+		SetMFA(context.Context, primitive.ObjectID, MFA) error
+		UseMFAStep(ctx context.Context, id primitive.ObjectID, step int64) error
+		UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error
+	}
+	LoginEvents interface {
+		Create(context.Context, *LoginEvent) error
+		ListByUser(ctx context.Context, userID primitive.ObjectID, limit int64) ([]LoginEvent, error)
+		RecentIPs(ctx context.Context, userID primitive.ObjectID, email string, since time.Time) ([]string, error)
+	}
+	Workspaces interface {
+		Create(context.Context, *Workspace) error
+		GetByID(context.Context, primitive.ObjectID) (*Workspace, error)
+		ListByIDs(context.Context, []primitive.ObjectID) ([]Workspace, error)
+		Rename(ctx context.Context, id primitive.ObjectID, name string) error
+		Delete(context.Context, primitive.ObjectID) error
+	}
+	Memberships interface {
+		Create(context.Context, *Membership) error
+		Get(ctx context.Context, workspaceID, userID primitive.ObjectID) (*Membership, error)
+		ListByWorkspace(context.Context, primitive.ObjectID) ([]Membership, error)
+		ListByUser(context.Context, primitive.ObjectID) ([]Membership, error)
+		UpdateRole(ctx context.Context, workspaceID, userID primitive.ObjectID, role string) error
+		Delete(ctx context.Context, workspaceID, userID primitive.ObjectID) error
+		SoleOwnerOf(context.Context, primitive.ObjectID) ([]primitive.ObjectID, error)
+		DeleteAllByWorkspace(context.Context, primitive.ObjectID) error
+		DeleteAllByUser(context.Context, primitive.ObjectID) error
+	}
+	Invitations interface {
+		Create(context.Context, *Invitation) error
+		GetByHash(context.Context, string) (*Invitation, error)
+		ListByWorkspace(context.Context, primitive.ObjectID) ([]Invitation, error)
+		Delete(ctx context.Context, workspaceID, id primitive.ObjectID) error
+		DeleteAllByWorkspace(context.Context, primitive.ObjectID) error
+	}
+	Transfers interface {
+		Create(context.Context, *Transfer) error
+		GetByID(context.Context, primitive.ObjectID) (*Transfer, error)
+		ListByURL(context.Context, primitive.ObjectID) ([]Transfer, error)
+		ListPendingFor(ctx context.Context, userID primitive.ObjectID, workspaceIDs []primitive.ObjectID) ([]Transfer, error)
+		Resolve(ctx context.Context, id primitive.ObjectID, from, to string, by *primitive.ObjectID) (*Transfer, error)
+		CancelPending(ctx context.Context, shortURLID primitive.ObjectID, by *primitive.ObjectID) error
+	}
+	AuditEvents interface {
+		Create(ctx context.Context, event *AuditEvent, retention time.Duration) error
+		CreateMany(ctx context.Context, events []*AuditEvent, retention time.Duration) error
+		List(context.Context, AuditQuery) ([]AuditEvent, error)
+	}
+	UserTokens interface {
+		Create(context.Context, *UserToken) error
+		Get(ctx context.Context, purpose, hash string) (*UserToken, error)
+		Consume(ctx context.Context, purpose, hash string) (*UserToken, error)
+		RecordFailure(ctx context.Context, id primitive.ObjectID, maxAttempts int) error
+		DeleteAllForUser(ctx context.Context, userID primitive.ObjectID, purpose string) error
+	}
+	RefreshTokens interface {
+		Create(context.Context, *RefreshToken) error
+		GetByHash(context.Context, string) (*RefreshToken, error)
+		Rotate(ctx context.Context, old, next *RefreshToken) error
+		RevokeFamily(context.Context, primitive.ObjectID) error
+		RevokeAllForUser(context.Context, primitive.ObjectID) error
+	}
+	RevokedTokens TokenDenylist
+	APIKeys       interface {
+		Create(context.Context, *APIKey) error
+		GetByPrefix(context.Context, string) (*APIKey, error)
+		ListByUser(context.Context, primitive.ObjectID) ([]APIKey, error)
+		Delete(ctx context.Context, userID, keyID primitive.ObjectID) error
+		Touch(context.Context, primitive.ObjectID, time.Time) error
+		DeleteAllByUser(context.Context, primitive.ObjectID) error
+	}
+	OIDCStates interface {
+		Create(context.Context, *OIDCState) error
+		Consume(ctx context.Context, provider, state string) (*OIDCState, error)
+	}
+	Identities interface {
+		Create(context.Context, *Identity) error
+		Get(ctx context.Context, provider, subject string) (*Identity, error)
+		DeleteAllByUser(context.Context, primitive.ObjectID) error
 	}
 }
 
-func NewStorage(db *mongo.Database) Storage {
+func NewStorage(db *mongo.Database, codes CodeGenerator) Storage {
 	return Storage{
-		Urls:  &ShortUrlsStore{db.Collection("urls")},
-		Users: &UserStore{db.Collection("users")},
+		Urls:          &ShortUrlsStore{collection: db.Collection("urls"), codes: codes},
+		Users:         &UserStore{db.Collection("users")},
+		Clicks:        &ClickStore{db.Collection("clicks")},
+		RefreshTokens: &RefreshTokenStore{db.Collection("refresh_tokens")},
+		RevokedTokens: &RevokedTokenStore{db.Collection("revoked_tokens")},
+		APIKeys:       &APIKeyStore{db.Collection("api_keys")},
+		OIDCStates:    &OIDCStateStore{db.Collection("oidc_states")},
+		Identities:    &IdentityStore{db.Collection("identities")},
+		UserTokens:    &UserTokenStore{db.Collection("user_tokens")},
+		LoginEvents:   &LoginEventStore{db.Collection("login_events")},
+		Workspaces:    &WorkspaceStore{db.Collection("workspaces")},
+		Memberships:   &MembershipStore{db.Collection("workspace_members"), db.Collection("workspaces")},
+		Invitations:   &InvitationStore{db.Collection("workspace_invitations")},
+		Transfers:     &TransferStore{db.Collection("transfers")},
+		AuditEvents:   &AuditEventStore{auditCollection(db)},
 	}
 }
+
+// isDuplicateKeyError reports whether err is a duplicate key error on the given index.
+func isDuplicateKeyError(err error, index string) bool {
+	if !mongo.IsDuplicateKeyError(err) {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(err.Error()), index)
+}
+
+// isDuplicateKeyWriteError is isDuplicateKeyError for one write of a bulk operation.
+func isDuplicateKeyWriteError(err mongo.WriteError, index string) bool {
+	return err.Code == 11000 && strings.Contains(strings.ToLower(err.Message), index)
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrTransferPending = errors.New("the link already has a pending transfer")
+
+// Transfer statuses. Only pending transfers change, the others are final.
+// (An accepted transfer is cancelled when the link turns out to have moved meanwhile.)
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferRejected  = "rejected"
+	TransferCancelled = "cancelled"
+	TransferForced    = "forced" // Moved by an administrator without asking the recipient
+)
+
+// LinkOwner is who a link belongs to: a workspace when WorkspaceID is set,
+// otherwise the user.
+type LinkOwner struct {
+	UserID      primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	WorkspaceID *primitive.ObjectID `bson:"workspace_id,omitempty" json:"workspace_id,omitempty"`
+}
+
+// Owner returns the current owner of the link.
+func (u *ShortURL) Owner() LinkOwner {
+	return LinkOwner{UserID: u.UserID, WorkspaceID: u.WorkspaceID}
+}
+
+// Equal reports whether both name the same owner.
+func (o LinkOwner) Equal(other LinkOwner) bool {
+	if o.WorkspaceID != nil || other.WorkspaceID != nil {
+		return o.WorkspaceID != nil && other.WorkspaceID != nil && *o.WorkspaceID == *other.WorkspaceID
+	}
+	return o.UserID == other.UserID
+}
+
+// Transfer moves a link to another owner once the recipient accepts it. Transfers
+// are never deleted, they are the record of who owned a link when.
+type Transfer struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ShortURLID  primitive.ObjectID  `bson:"short_url_id" json:"-"`
+	ShortCode   string              `bson:"short_code" json:"short_code"`
+	From        LinkOwner           `bson:"from" json:"from"`
+	To          LinkOwner           `bson:"to" json:"to"`
+	Status      string              `bson:"status" json:"status"`
+	RequestedBy *primitive.ObjectID `bson:"requested_by,omitempty" json:"requested_by,omitempty"` // Unset for administrators signed in with basic auth
+	ResolvedBy  *primitive.ObjectID `bson:"resolved_by,omitempty" json:"resolved_by,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+	ResolvedAt  *time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+}
+
+type TransferStore struct {
+	collection *mongo.Collection
+}
+
+// Create saves a new transfer. A link can only have one pending transfer at a
+// time, another one returns ErrTransferPending.
+func (s *TransferStore) Create(ctx context.Context, transfer *Transfer) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	transfer.CreatedAt = time.Now()
+	if transfer.Status != TransferPending {
+		transfer.ResolvedAt = &transfer.CreatedAt
+	}
+
+	res, err := s.collection.InsertOne(ctx, transfer)
+	if err != nil {
+		if isDuplicateKeyError(err, "unique_pending_transfer") {
+			return ErrTransferPending
+		}
+		return err
+	}
+
+	transfer.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (s *TransferStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Transfer, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var transfer Transfer
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&transfer)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// ListByURL returns the transfers of a link, newest first.
+func (s *TransferStore) ListByURL(ctx context.Context, shortURLID primitive.ObjectID) ([]Transfer, error) {
+	return s.list(ctx, bson.M{"short_url_id": shortURLID})
+}
+
+// ListPendingFor returns the transfers waiting for the user, either addressed
+// to them or to one of workspaceIDs.
+func (s *TransferStore) ListPendingFor(ctx context.Context, userID primitive.ObjectID, workspaceIDs []primitive.ObjectID) ([]Transfer, error) {
+	recipients := bson.A{bson.M{"to.user_id": userID, "to.workspace_id": nil}}
+	if len(workspaceIDs) > 0 {
+		recipients = append(recipients, bson.M{"to.workspace_id": bson.M{"$in": workspaceIDs}})
+	}
+
+	return s.list(ctx, bson.M{"status": TransferPending, "$or": recipients})
+}
+
+func (s *TransferStore) list(ctx context.Context, filter bson.M) ([]Transfer, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	transfers := []Transfer{}
+	if err := cursor.All(ctx, &transfers); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// Resolve moves a transfer from one status to another. It returns ErrNotFound when
+// the transfer doesn't exist or isn't in status from anymore, so only one caller wins.
+func (s *TransferStore) Resolve(ctx context.Context, id primitive.ObjectID, from, to string, by *primitive.ObjectID) (*Transfer, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	set := bson.M{"status": to, "resolved_at": time.Now()}
+	if by != nil {
+		set["resolved_by"] = by
+	}
+
+	var transfer Transfer
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": from},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&transfer)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// CancelPending cancels the pending transfer of a link, if there is one.
+func (s *TransferStore) CancelPending(ctx context.Context, shortURLID primitive.ObjectID, by *primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	set := bson.M{"status": TransferCancelled, "resolved_at": time.Now()}
+	if by != nil {
+		set["resolved_by"] = by
+	}
+
+	_, err := s.collection.UpdateMany(ctx,
+		bson.M{"short_url_id": shortURLID, "status": TransferPending},
+		bson.M{"$set": set},
+	)
+	return err
+}
@@ -1,47 +1,58 @@
 package store
 
 import (
-	"Url-Shortener/internal/base62"
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"sync"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
-// 15 days
-var defaultExpiration = 24 * time.Hour * 15
+var ErrDuplicateShortCode = errors.New("short code is already taken")
 
-var mu sync.Mutex
-var currentSequence int64 = 0
-var machineID int64 = 164
+// maxCreateAttempts bounds how often Create retries with a fresh code when
+// the generated one is already taken (e.g. by a link from the old generator).
+const maxCreateAttempts = 5
 
-const maxSequence int64 = 9999
-
-func nextID() int64 {
-	mu.Lock()
-	defer mu.Unlock()
+type ShortURL struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"-"`
+	ShortCode   string              `bson:"short_code" json:"short_code"`                         // Unique short identifier
+	OriginalURL string              `bson:"original_url" json:"original_url"`                     // The original long URL
+	Domain      string              `bson:"domain" json:"domain"`                                 // Host of OriginalURL, for filtering
+	UserID      primitive.ObjectID  `bson:"user_id" json:"user_id"`                               // Reference to User
+	WorkspaceID *primitive.ObjectID `bson:"workspace_id,omitempty" json:"workspace_id,omitempty"` // Set when a workspace owns the link rather than the user
+	OwnerID     primitive.ObjectID  `bson:"owner_id" json:"-"`                                    // WorkspaceID when set, else UserID, the prefix of the text index
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`                         // Creation timestamp
+	ExpiresAt   *time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"`     // Optional expiration timestamp, nil never expires
+	VisitCount  uint64              `bson:"visit_count" json:"visit_count"`                       // Total visit count
+	Disabled    bool                `bson:"disabled" json:"disabled"`                             // Disabled links don't redirect
+	Version     int64               `bson:"version" json:"version"`                               // Bumped on every update, used for optimistic concurrency
+}
 
-	currentSequence = (currentSequence + 1) % maxSequence
-	id := machineID*10000 + currentSequence
-	return id
+// linkOwnerID is the owner_id of a link of the user or, when set, the workspace.
+func linkOwnerID(userID primitive.ObjectID, workspaceID *primitive.ObjectID) primitive.ObjectID {
+	if workspaceID != nil {
+		return *workspaceID
+	}
+	return userID
 }
 
-type ShortURL struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
-	ShortCode   string             `bson:"short_code" json:"short_code"`                     // Unique short identifier
-	OriginalURL string             `bson:"original_url" json:"original_url"`                 // The original long URL
-	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`                           // Reference to User
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`                     // Creation timestamp
-	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Optional expiration timestamp
-	VisitCount  uint64             `bson:"visit_count" json:"visit_count"`                   // Total visit count
+// IsExpired reports whether the link is past its expiration. Mongo's TTL monitor
+// only sweeps about once a minute, so expired documents can still be read for a while.
+func (u *ShortURL) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
 }
 
 type ShortUrlsStore struct {
 	collection *mongo.Collection
+	codes      CodeGenerator
 }
 
 func (s *ShortUrlsStore) Create(ctx context.Context, shortURL *ShortURL) error {
@@ -50,42 +61,131 @@ func (s *ShortUrlsStore) Create(ctx context.Context, shortURL *ShortURL) error {
 
 	now := time.Now()
 
-	id := nextID()
-	shortCode := base62.Encode(id)
-
-	shortURL.ShortCode = shortCode
-
 	if shortURL.CreatedAt.IsZero() {
 		shortURL.CreatedAt = now
 	}
-	if shortURL.ExpiresAt == nil {
-		exp := now.Add(defaultExpiration)
-		shortURL.ExpiresAt = &exp
-	}
 
 	shortURL.VisitCount = 0
+	shortURL.Version = 1
+	shortURL.Domain = URLDomain(shortURL.OriginalURL)
+	shortURL.OwnerID = linkOwnerID(shortURL.UserID, shortURL.WorkspaceID)
 
-	_, err := s.collection.InsertOne(ctx, shortURL)
-	return err
+	// Caller-chosen aliases are inserted as-is, a clash is the caller's problem.
+	if shortURL.ShortCode != "" {
+		_, err := s.collection.InsertOne(ctx, shortURL)
+		if isDuplicateKeyError(err, "unique_short_code") {
+			return ErrDuplicateShortCode
+		}
+		return err
+	}
+
+	for attempt := 1; attempt <= maxCreateAttempts; attempt++ {
+		shortCode, err := s.codes.Generate(ctx)
+		if err != nil {
+			return err
+		}
+
+		shortURL.ShortCode = shortCode
+
+		_, err = s.collection.InsertOne(ctx, shortURL)
+		if err == nil {
+			return nil
+		}
+		if !isDuplicateKeyError(err, "unique_short_code") {
+			return err
+		}
+	}
+
+	return fmt.Errorf("could not generate a unique short code after %d attempts: %w", maxCreateAttempts, ErrConflict)
 }
 
-func (s *ShortUrlsStore) GetByShortCode(ctx context.Context, shortCode string) (*ShortURL, error) {
+// CreateMany inserts the links in as few round trips as possible. It returns one
+// error per link, nil for the ones created; a failing link doesn't stop the others.
+// As with Create, taken aliases fail with ErrDuplicateShortCode while taken
+// generated codes are replaced and retried.
+func (s *ShortUrlsStore) CreateMany(ctx context.Context, urls []*ShortURL) []error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	// Find and increment VisitCount atomically
-	filter := bson.M{"short_code": shortCode}
-	update := bson.M{"$inc": bson.M{"visit_count": 1}}
+	errs := make([]error, len(urls))
+	generated := make([]bool, len(urls))
+	pending := make([]int, 0, len(urls))
+
+	now := time.Now()
+	for i, shortURL := range urls {
+		if shortURL.CreatedAt.IsZero() {
+			shortURL.CreatedAt = now
+		}
+		shortURL.ID = primitive.NewObjectID()
+		shortURL.VisitCount = 0
+		shortURL.Version = 1
+		shortURL.Domain = URLDomain(shortURL.OriginalURL)
+		shortURL.OwnerID = linkOwnerID(shortURL.UserID, shortURL.WorkspaceID)
+
+		generated[i] = shortURL.ShortCode == ""
+		pending = append(pending, i)
+	}
 
-	var updated ShortURL
-	err := s.collection.FindOneAndUpdate(
-		ctx,
-		filter,
-		update,
-		options.FindOneAndUpdate().
-			SetReturnDocument(options.After), // Return updated doc
-	).Decode(&updated)
+	for attempt := 1; attempt <= maxCreateAttempts && len(pending) > 0; attempt++ {
+		docs := make([]any, len(pending))
+		for j, i := range pending {
+			if generated[i] {
+				shortCode, err := s.codes.Generate(ctx)
+				if err != nil {
+					for _, i := range pending {
+						errs[i] = err
+					}
+					return errs
+				}
+				urls[i].ShortCode = shortCode
+			}
+			docs[j] = urls[i]
+		}
+
+		_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err == nil {
+			return errs
+		}
+
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+			for _, i := range pending {
+				errs[i] = err
+			}
+			return errs
+		}
+
+		// Write errors point into docs, the others were inserted
+		retry := []int{}
+		for _, writeErr := range bulkErr.WriteErrors {
+			i := pending[writeErr.Index]
+
+			switch {
+			case !isDuplicateKeyWriteError(writeErr.WriteError, "unique_short_code"):
+				errs[i] = writeErr.WriteError
+			case generated[i]:
+				retry = append(retry, i)
+			default:
+				errs[i] = ErrDuplicateShortCode
+			}
+		}
+		pending = retry
+	}
+
+	for _, i := range pending {
+		urls[i].ShortCode = ""
+		errs[i] = fmt.Errorf("could not generate a unique short code after %d attempts: %w", maxCreateAttempts, ErrConflict)
+	}
+
+	return errs
+}
 
+func (s *ShortUrlsStore) GetByShortCode(ctx context.Context, shortCode string) (*ShortURL, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var shortURL ShortURL
+	err := s.collection.FindOne(ctx, bson.M{"short_code": shortCode}).Decode(&shortURL)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, ErrNotFound
@@ -93,29 +193,454 @@ func (s *ShortUrlsStore) GetByShortCode(ctx context.Context, shortCode string) (
 		return nil, err
 	}
 
-	return &updated, nil
+	return &shortURL, nil
+}
+
+// IncrementVisits adds the given amounts to the visit counters in a single round trip.
+func (s *ShortUrlsStore) IncrementVisits(ctx context.Context, visits map[primitive.ObjectID]uint64) error {
+	if len(visits) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(visits))
+	for id, n := range visits {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$inc": bson.M{"visit_count": int64(n)}}))
+	}
+
+	_, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// Sort orders of ListPage
+const (
+	SortCreatedAt  = "created_at"
+	SortVisitCount = "visit_count"
+	SortExpiresAt  = "expires_at" // Links that never expire come first ascending, last descending
+)
+
+// Link statuses ListPage can filter on
+const (
+	UrlStatusActive   = "active"
+	UrlStatusExpired  = "expired" // Past their expiration but not yet removed by the TTL monitor
+	UrlStatusDisabled = "disabled"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UrlPageQuery selects a page of links for ListPage. Either UserID, for the
+// user's personal links, or WorkspaceID must be set.
+type UrlPageQuery struct {
+	UserID      primitive.ObjectID
+	WorkspaceID *primitive.ObjectID
+	Search      string // Full-text search over destinations and short codes
+	Status      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Domain      string // Host of the destination, "www." is ignored
+	Sort        string // Defaults to SortCreatedAt
+	Descending  bool
+	Cursor      string // Next cursor of the previous page, empty for the first one
+	Limit       int64
+}
+
+// urlCursor is the position of the last link of a page, it only makes sense
+// for the same sort order.
+type urlCursor struct {
+	Sort       string             `bson:"s"`
+	Descending bool               `bson:"d"`
+	Value      any                `bson:"v"` // Sort field of the last link, nil when it never expires
+	ID         primitive.ObjectID `bson:"i"`
+}
+
+func (c *urlCursor) encode() (string, error) {
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeUrlCursor(s string) (*urlCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c urlCursor
+	if err := bson.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// Clients can send anything, only values ListPage could have written get into the filter
+	if !c.valid() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// valid reports whether Value has the type ListPage writes for Sort.
+func (c *urlCursor) valid() bool {
+	switch c.Sort {
+	case SortCreatedAt:
+		_, ok := c.Value.(primitive.DateTime)
+		return ok
+	case SortExpiresAt:
+		_, ok := c.Value.(primitive.DateTime)
+		return ok || c.Value == nil
+	case SortVisitCount:
+		_, ok := c.Value.(int64)
+		return ok
+	default:
+		return false
+	}
+}
+
+// ListPage returns a page of links matching q and the cursor of the next page,
+// empty on the last one. Pages are cut by the sort field and the ID rather than
+// skipped over, so they stay fast and stable however deep the client pages.
+func (s *ShortUrlsStore) ListPage(ctx context.Context, q UrlPageQuery) ([]ShortURL, string, error) {
+	if q.Sort == "" {
+		q.Sort = SortCreatedAt
+	}
+
+	conditions := bson.A{}
+	if q.WorkspaceID != nil {
+		conditions = append(conditions, bson.M{"workspace_id": q.WorkspaceID})
+	} else {
+		conditions = append(conditions, bson.M{"user_id": q.UserID, "workspace_id": nil})
+	}
+
+	if q.Search != "" {
+		// The text index is prefixed by the owner, it takes an exact match on it
+		conditions = append(conditions,
+			bson.M{"owner_id": linkOwnerID(q.UserID, q.WorkspaceID)},
+			bson.M{"$text": bson.M{"$search": q.Search}},
+		)
+	}
+
+	now := time.Now()
+	switch q.Status {
+	case UrlStatusActive:
+		conditions = append(conditions,
+			bson.M{"disabled": bson.M{"$ne": true}},
+			bson.M{"$or": bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}}},
+		)
+	case UrlStatusExpired:
+		conditions = append(conditions, bson.M{"expires_at": bson.M{"$lte": now}})
+	case UrlStatusDisabled:
+		conditions = append(conditions, bson.M{"disabled": true})
+	}
+
+	if q.CreatedFrom != nil {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$gte": q.CreatedFrom}})
+	}
+	if q.CreatedTo != nil {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$lt": q.CreatedTo}})
+	}
+
+	if q.Domain != "" {
+		conditions = append(conditions, bson.M{"domain": normalizeDomain(q.Domain)})
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeUrlCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if cursor.Sort != q.Sort || cursor.Descending != q.Descending {
+			return nil, "", ErrInvalidCursor
+		}
+		conditions = append(conditions, afterUrlCursor(cursor))
+	}
+
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+
+	// One extra link tells whether there is another page
+	opts := options.Find().
+		SetSort(bson.D{{Key: q.Sort, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(q.Limit + 1)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"$and": conditions}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	urls := []ShortURL{}
+	if err := cursor.All(ctx, &urls); err != nil {
+		return nil, "", err
+	}
+
+	if int64(len(urls)) <= q.Limit {
+		return urls, "", nil
+	}
+
+	urls = urls[:q.Limit]
+	last := urls[len(urls)-1]
+
+	next := &urlCursor{Sort: q.Sort, Descending: q.Descending, ID: last.ID}
+	switch q.Sort {
+	case SortCreatedAt:
+		next.Value = last.CreatedAt
+	case SortVisitCount:
+		next.Value = int64(last.VisitCount)
+	case SortExpiresAt:
+		if last.ExpiresAt != nil {
+			next.Value = *last.ExpiresAt
+		}
+	}
+
+	token, err := next.encode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return urls, token, nil
+}
+
+// afterUrlCursor matches the links sorted after the cursor. A missing
+// expiration sorts before every date, like Mongo does.
+func afterUrlCursor(c *urlCursor) bson.M {
+	op := "$gt"
+	if c.Descending {
+		op = "$lt"
+	}
+
+	if c.Value == nil {
+		if c.Descending {
+			// Links that never expire are the last ones
+			return bson.M{c.Sort: nil, "_id": bson.M{op: c.ID}}
+		}
+		return bson.M{"$or": bson.A{
+			bson.M{c.Sort: nil, "_id": bson.M{op: c.ID}},
+			bson.M{c.Sort: bson.M{"$ne": nil}},
+		}}
+	}
+
+	after := bson.A{
+		bson.M{c.Sort: bson.M{op: c.Value}},
+		bson.M{c.Sort: c.Value, "_id": bson.M{op: c.ID}},
+	}
+	if c.Descending && c.Sort == SortExpiresAt {
+		after = append(after, bson.M{c.Sort: nil})
+	}
+
+	return bson.M{"$or": after}
+}
+
+// URLDomain returns the host a link points to, the way ListPage filters on it.
+func URLDomain(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return normalizeDomain(u.Hostname())
+}
+
+func normalizeDomain(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// Update saves the mutable fields of shortURL as long as nobody else changed the
+// document since shortURL.Version was read, otherwise it returns ErrEditConflict.
+func (s *ShortUrlsStore) Update(ctx context.Context, shortURL *ShortURL) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	filter := bson.M{"_id": shortURL.ID, "version": shortURL.Version}
+	if shortURL.Version == 0 {
+		// Links created before versioning have no version field at all.
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	shortURL.Domain = URLDomain(shortURL.OriginalURL)
+
+	set := bson.M{
+		"original_url": shortURL.OriginalURL,
+		"domain":       shortURL.Domain,
+		"disabled":     shortURL.Disabled,
+	}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if shortURL.ExpiresAt != nil {
+		set["expires_at"] = shortURL.ExpiresAt
+	} else {
+		update["$unset"] = bson.M{"expires_at": ""}
+	}
+
+	res, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	shortURL.Version++
+
+	return nil
+}
+
+// TransferOwner moves the link from one owner to another, as long as it still
+// belongs to from. Otherwise it returns ErrEditConflict.
+func (s *ShortUrlsStore) TransferOwner(ctx context.Context, id primitive.ObjectID, from, to LinkOwner) (*ShortURL, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "workspace_id": from.WorkspaceID}
+	if from.WorkspaceID == nil {
+		filter["workspace_id"] = nil
+		filter["user_id"] = from.UserID
+	}
+
+	set := bson.M{"user_id": to.UserID, "owner_id": linkOwnerID(to.UserID, to.WorkspaceID)}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if to.WorkspaceID != nil {
+		set["workspace_id"] = to.WorkspaceID
+	} else {
+		update["$unset"] = bson.M{"workspace_id": ""}
+	}
+
+	var shortURL ShortURL
+	err := s.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&shortURL)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrEditConflict
+		}
+		return nil, err
+	}
+
+	return &shortURL, nil
 }
 
-func (s *ShortUrlsStore) GetAllUrlsByUser(ctx context.Context, userID primitive.ObjectID) ([]ShortURL, error) {
+// UrlQuery filters the links listed by List.
+type UrlQuery struct {
+	Search string             // Part of the short code or destination, case insensitive
+	UserID primitive.ObjectID // Only links of this user when set
+	Limit  int64
+	Offset int64
+}
+
+// List returns links of every user matching q, newest first.
+func (s *ShortUrlsStore) List(ctx context.Context, q UrlQuery) ([]ShortURL, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	filter := bson.M{"user_id": userID}
+	filter := bson.M{}
+	if q.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q.Search), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"short_code": pattern},
+			bson.M{"original_url": pattern},
+		}
+	}
+	if !q.UserID.IsZero() {
+		filter["user_id"] = q.UserID
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(q.Offset).
+		SetLimit(q.Limit)
 
-	cursor, err := s.collection.Find(ctx, filter)
+	cursor, err := s.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var urls []ShortURL
-	if err = cursor.All(ctx, &urls); err != nil {
+	urls := []ShortURL{}
+	if err := cursor.All(ctx, &urls); err != nil {
 		return nil, err
 	}
 
 	return urls, nil
 }
 
+// deleteBatchSize bounds how many links DeleteAllByUser loads at a time.
+const deleteBatchSize = 1000
+
+// DeleteAllByUser deletes the user's personal links, those owned by a workspace
+// stay. The deleted links are returned with only the ID and short code set, so
+// caches and click data can be cleaned up.
+func (s *ShortUrlsStore) DeleteAllByUser(ctx context.Context, userID primitive.ObjectID) ([]ShortURL, error) {
+	return s.deleteAll(ctx, bson.M{"user_id": userID, "workspace_id": nil})
+}
+
+// DeleteAllByWorkspace deletes the workspace's links, returned like DeleteAllByUser does.
+func (s *ShortUrlsStore) DeleteAllByWorkspace(ctx context.Context, workspaceID primitive.ObjectID) ([]ShortURL, error) {
+	return s.deleteAll(ctx, bson.M{"workspace_id": workspaceID})
+}
+
+func (s *ShortUrlsStore) deleteAll(ctx context.Context, filter bson.M) ([]ShortURL, error) {
+	var deleted []ShortURL
+
+	for {
+		batch, err := s.deleteBatch(ctx, filter)
+		if err != nil {
+			return deleted, err
+		}
+		if len(batch) == 0 {
+			return deleted, nil
+		}
+
+		deleted = append(deleted, batch...)
+	}
+}
+
+func (s *ShortUrlsStore) deleteBatch(ctx context.Context, filter bson.M) ([]ShortURL, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "short_code": 1}).
+		SetLimit(deleteBatchSize)
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []ShortURL
+	if err := cursor.All(ctx, &batch); err != nil {
+		return nil, err
+	}
+	if len(batch) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(batch))
+	for i, u := range batch {
+		ids[i] = u.ID
+	}
+
+	if _, err := s.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
 func (s *ShortUrlsStore) Delete(ctx context.Context, shortCode string) error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
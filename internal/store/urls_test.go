@@ -0,0 +1,70 @@
+package store
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUrlCursorRoundTrip(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+
+	for _, c := range []urlCursor{
+		{Sort: SortCreatedAt, Value: now},
+		{Sort: SortVisitCount, Value: int64(42), Descending: true},
+		{Sort: SortExpiresAt, Value: now},
+		{Sort: SortExpiresAt, Value: nil}, // Never expires
+	} {
+		c.ID = primitive.NewObjectID()
+
+		token, err := c.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := decodeUrlCursor(token)
+		if err != nil {
+			t.Errorf("%s %v: %v", c.Sort, c.Value, err)
+			continue
+		}
+		if decoded.Sort != c.Sort || decoded.Descending != c.Descending || decoded.ID != c.ID {
+			t.Errorf("decoded %+v, want %+v", decoded, c)
+		}
+	}
+}
+
+func TestDecodeUrlCursorRejectsUnexpectedValues(t *testing.T) {
+	for name, value := range map[string]struct {
+		sort  string
+		value any
+	}{
+		"operator document": {SortCreatedAt, bson.M{"$gt": ""}},
+		"string date":       {SortCreatedAt, "2025-01-01"},
+		"missing date":      {SortCreatedAt, nil},
+		"int32 count":       {SortVisitCount, int32(1)},
+		"date count":        {SortVisitCount, time.Now()},
+		"missing count":     {SortVisitCount, nil},
+		"number expiry":     {SortExpiresAt, int64(1)},
+		"array expiry":      {SortExpiresAt, bson.A{time.Now()}},
+		"unknown sort":      {"short_code", "abc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Marshaled by hand, the way a client could forge one
+			data, err := bson.Marshal(bson.M{"s": value.sort, "v": value.value, "i": primitive.NewObjectID()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := decodeUrlCursor(base64.RawURLEncoding.EncodeToString(data)); err != ErrInvalidCursor {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+
+	if _, err := decodeUrlCursor("not base64!"); err != ErrInvalidCursor {
+		t.Errorf("garbage: err = %v, want ErrInvalidCursor", err)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// What a UserToken may be used for
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeMFA           = "mfa" // Password was right, waiting for the second factor
+)
+
+// UserToken is a single use token mailed to a user, only its hash is stored.
+type UserToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	Attempts  int                `bson:"attempts"`
+}
+
+type UserTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *UserTokenStore) Create(ctx context.Context, token *UserToken) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	token.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// Get returns the token without using it up.
+func (s *UserTokenStore) Get(ctx context.Context, purpose, hash string) (*UserToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var token UserToken
+	err := s.collection.FindOne(ctx, bson.M{
+		"token_hash": hash,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RecordFailure counts a wrong guess made with the token and deletes it after maxAttempts.
+func (s *UserTokenStore) RecordFailure(ctx context.Context, id primitive.ObjectID, maxAttempts int) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var token UserToken
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+
+	if token.Attempts >= maxAttempts {
+		_, err = s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	}
+
+	return err
+}
+
+// Consume returns the token and deletes it, so it can only be used once.
+// Expired tokens are treated as missing, the TTL index may not have removed them yet.
+func (s *UserTokenStore) Consume(ctx context.Context, purpose, hash string) (*UserToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var token UserToken
+	err := s.collection.FindOneAndDelete(ctx, bson.M{
+		"token_hash": hash,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteAllForUser drops the user's outstanding tokens for purpose.
+func (s *UserTokenStore) DeleteAllForUser(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose})
+	return err
+}
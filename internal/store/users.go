@@ -6,6 +6,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,15 +17,35 @@ import (
 var (
 	ErrDuplicateEmail    = errors.New("a user with that email already exists")
 	ErrDuplicateUsername = errors.New("a user with that username already exists")
+	ErrMFACodeReused     = errors.New("two-factor code was already used")
+)
+
+// Roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
 )
 
 type User struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Username  string             `bson:"username" json:"username"`
-	Email     string             `bson:"email" json:"email"`
+	Email     string             `bson:"email" json:"email"` // Always stored through NormalizeEmail
 	Password  password           `bson:"password,omitempty" json:"-"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 	IsActive  bool               `bson:"is_active" json:"is_active"`
+	Role      string             `bson:"role" json:"role"`
+
+	EmailVerified bool `bson:"email_verified" json:"email_verified"`
+	MFA           MFA  `bson:"mfa" json:"mfa"`
+}
+
+// MFA is the user's TOTP second factor. A secret without Enabled is an
+// enrollment waiting to be confirmed with a first code.
+type MFA struct {
+	Enabled       bool     `bson:"enabled" json:"enabled"`
+	Secret        string   `bson:"secret,omitempty" json:"-"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"` // Hashed, removed once used
+	LastUsedStep  int64    `bson:"last_used_step" json:"-"`           // Codes from this step or earlier are refused
 }
 
 type password struct {
@@ -73,28 +95,43 @@ func (s *UserStore) Create(ctx context.Context, user *User) error {
 
 	user.CreatedAt = time.Now()
 	user.IsActive = true
+	user.Email = NormalizeEmail(user.Email)
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
 
 	_, err := s.collection.InsertOne(ctx, user)
 	if err != nil {
-		var writeErr mongo.WriteException
-		if errors.As(err, &writeErr) {
-			for _, we := range writeErr.WriteErrors {
-				if we.Code == 11000 { // Duplicate key error
-					msg := strings.ToLower(we.Message) // normalize case
-					if strings.Contains(msg, "unique_email") {
-						return ErrDuplicateEmail
-					}
-					if strings.Contains(msg, "unique_username") {
-						return ErrDuplicateUsername
-					}
-					// Optionally return a generic duplicate key error here if needed
+		return userWriteError(err)
+	}
+
+	return nil
+}
+
+// userWriteError maps duplicate key errors on the unique indexes to their own errors.
+func userWriteError(err error) error {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, we := range writeErr.WriteErrors {
+			if we.Code == 11000 { // Duplicate key error
+				msg := strings.ToLower(we.Message) // normalize case
+				if strings.Contains(msg, "unique_email") {
+					return ErrDuplicateEmail
+				}
+				if strings.Contains(msg, "unique_username") {
+					return ErrDuplicateUsername
 				}
+				// Optionally return a generic duplicate key error here if needed
 			}
 		}
-		return err
 	}
+	return err
+}
 
-	return nil
+// NormalizeEmail is the form emails are stored and looked up in. Addresses
+// differing only in case are the same user, whatever typed or sent them.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
@@ -102,7 +139,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	defer cancel()
 
 	var user User
-	err := s.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	err := s.collection.FindOne(ctx, bson.M{"email": NormalizeEmail(email)}).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, ErrNotFound
@@ -112,3 +149,188 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 
 	return &user, nil
 }
+
+// Update saves the profile fields of user, the password and MFA have their own methods.
+func (s *UserStore) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user.Email = NormalizeEmail(user.Email)
+
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}})
+	if err != nil {
+		return userWriteError(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *UserStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// UserQuery filters the users listed by List.
+type UserQuery struct {
+	Search string // Part of the username or email, case insensitive
+	Limit  int64
+	Offset int64
+}
+
+// List returns users matching q, newest first.
+func (s *UserStore) List(ctx context.Context, q UserQuery) ([]User, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	filter := bson.M{}
+	if q.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q.Search), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"username": pattern},
+			bson.M{"email": pattern},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(q.Offset).
+		SetLimit(q.Limit)
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *UserStore) SetActive(ctx context.Context, id primitive.ObjectID, active bool) error {
+	return s.set(ctx, id, bson.M{"is_active": active})
+}
+
+func (s *UserStore) SetRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	return s.set(ctx, id, bson.M{"role": role})
+}
+
+func (s *UserStore) set(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *UserStore) SetEmailVerified(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"email_verified": true}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password.hash": user.Password.Hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *UserStore) SetMFA(ctx context.Context, id primitive.ObjectID, mfa MFA) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"mfa": mfa}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// UseMFAStep records that the code of step was used. It returns ErrMFACodeReused
+// when a code of that step, or a later one, was already accepted.
+func (s *UserStore) UseMFAStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "mfa.enabled": true, "mfa.last_used_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"mfa.last_used_step": step}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrMFACodeReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code with the given hash, it returns
+// ErrNotFound when the user has no such code.
+func (s *UserStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "mfa.enabled": true, "mfa.recovery_codes": hash},
+		bson.M{"$pull": bson.M{"mfa.recovery_codes": hash}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
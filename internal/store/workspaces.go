@@ -0,0 +1,488 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrAlreadyMember = errors.New("user is already a member of the workspace")
+	ErrLastOwner     = errors.New("a workspace must keep at least one owner")
+)
+
+// Workspace roles, each one can do everything the previous one can
+const (
+	WorkspaceRoleViewer = "viewer" // Sees the links and their stats
+	WorkspaceRoleEditor = "editor" // Creates, edits and deletes links
+	WorkspaceRoleAdmin  = "admin"  // Manages members and invitations
+	WorkspaceRoleOwner  = "owner"  // Renames and deletes the workspace, manages owners
+)
+
+var workspaceRoleRanks = map[string]int{
+	WorkspaceRoleViewer: 1,
+	WorkspaceRoleEditor: 2,
+	WorkspaceRoleAdmin:  3,
+	WorkspaceRoleOwner:  4,
+}
+
+// WorkspaceRoleAtLeast reports whether role grants everything min does.
+func WorkspaceRoleAtLeast(role, min string) bool {
+	rank, ok := workspaceRoleRanks[role]
+	return ok && rank >= workspaceRoleRanks[min]
+}
+
+// Workspace owns links together with its members, so links outlive the people who made them.
+type Workspace struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	CreatedBy  primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	OwnerCount int                `bson:"owner_count" json:"-"` // Kept by MembershipStore, guards the last owner
+}
+
+type WorkspaceStore struct {
+	collection *mongo.Collection
+}
+
+func (s *WorkspaceStore) Create(ctx context.Context, workspace *Workspace) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	workspace.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	workspace.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (s *WorkspaceStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Workspace, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var workspace Workspace
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&workspace)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+func (s *WorkspaceStore) ListByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Workspace, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	workspaces := []Workspace{}
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return nil, err
+	}
+
+	return workspaces, nil
+}
+
+func (s *WorkspaceStore) Rename(ctx context.Context, id primitive.ObjectID, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": name}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *WorkspaceStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type Membership struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID primitive.ObjectID `bson:"workspace_id" json:"workspace_id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role        string             `bson:"role" json:"role"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// MembershipStore keeps the owner count on the workspace document in step with
+// the members. Without transactions, taking the owner role away first takes one
+// off the count with a write that refuses to go below one owner, so concurrent
+// demotions can't leave a workspace without owners.
+type MembershipStore struct {
+	collection *mongo.Collection
+	workspaces *mongo.Collection
+}
+
+func (s *MembershipStore) Create(ctx context.Context, membership *Membership) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	membership.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, membership)
+	if err != nil {
+		if isDuplicateKeyError(err, "unique_workspace_user") {
+			return ErrAlreadyMember
+		}
+		return err
+	}
+
+	membership.ID = res.InsertedID.(primitive.ObjectID)
+
+	if membership.Role == WorkspaceRoleOwner {
+		return s.addOwner(ctx, membership.WorkspaceID)
+	}
+
+	return nil
+}
+
+func (s *MembershipStore) Get(ctx context.Context, workspaceID, userID primitive.ObjectID) (*Membership, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var membership Membership
+	err := s.collection.FindOne(ctx, bson.M{"workspace_id": workspaceID, "user_id": userID}).Decode(&membership)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+func (s *MembershipStore) ListByWorkspace(ctx context.Context, workspaceID primitive.ObjectID) ([]Membership, error) {
+	return s.list(ctx, bson.M{"workspace_id": workspaceID})
+}
+
+func (s *MembershipStore) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]Membership, error) {
+	return s.list(ctx, bson.M{"user_id": userID})
+}
+
+func (s *MembershipStore) list(ctx context.Context, filter bson.M) ([]Membership, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	memberships := []Membership{}
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// UpdateRole changes the member's role. Demoting the last owner returns ErrLastOwner,
+// and ErrEditConflict is returned when the role changed since it was read.
+func (s *MembershipStore) UpdateRole(ctx context.Context, workspaceID, userID primitive.ObjectID, role string) error {
+	member, err := s.Get(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+
+	wasOwner := member.Role == WorkspaceRoleOwner
+	isOwner := role == WorkspaceRoleOwner
+
+	if wasOwner && !isOwner {
+		if err := s.removeOwner(ctx, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	// Only the request that sees the role it read changes it, so the count moves once
+	err = s.setRole(ctx, workspaceID, userID, member.Role, role)
+	if err != nil {
+		if wasOwner && !isOwner {
+			s.undoRemoveOwner(ctx, workspaceID)
+		}
+		return err
+	}
+
+	if isOwner && !wasOwner {
+		return s.addOwner(ctx, workspaceID)
+	}
+
+	return nil
+}
+
+func (s *MembershipStore) setRole(ctx context.Context, workspaceID, userID primitive.ObjectID, from, to string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.UpdateOne(ctx,
+		bson.M{"workspace_id": workspaceID, "user_id": userID, "role": from},
+		bson.M{"$set": bson.M{"role": to}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Delete removes the member. Removing the last owner returns ErrLastOwner.
+func (s *MembershipStore) Delete(ctx context.Context, workspaceID, userID primitive.ObjectID) error {
+	member, err := s.Get(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+
+	isOwner := member.Role == WorkspaceRoleOwner
+	if isOwner {
+		if err := s.removeOwner(ctx, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// Conditional on the role, a concurrent promotion would have counted this member again
+	res, err := s.collection.DeleteOne(ctx, bson.M{"workspace_id": workspaceID, "user_id": userID, "role": member.Role})
+	if err == nil && res.DeletedCount == 0 {
+		err = ErrEditConflict
+	}
+	if err != nil {
+		if isOwner {
+			s.undoRemoveOwner(ctx, workspaceID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *MembershipStore) addOwner(ctx context.Context, workspaceID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.workspaces.UpdateOne(ctx, bson.M{"_id": workspaceID}, bson.M{"$inc": bson.M{"owner_count": 1}})
+	return err
+}
+
+// removeOwner takes one off the workspace's owner count, or returns ErrLastOwner
+// when only one is left. The check and the write are one conditional update.
+func (s *MembershipStore) removeOwner(ctx context.Context, workspaceID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.workspaces.UpdateOne(ctx,
+		bson.M{"_id": workspaceID, "owner_count": bson.M{"$gt": 1}},
+		bson.M{"$inc": bson.M{"owner_count": -1}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// undoRemoveOwner gives the count back when the membership write after removeOwner failed.
+func (s *MembershipStore) undoRemoveOwner(ctx context.Context, workspaceID primitive.ObjectID) {
+	// A failure here undercounts, which only ever refuses a demotion it could have allowed
+	s.addOwner(context.WithoutCancel(ctx), workspaceID)
+}
+
+// SoleOwnerOf returns the workspaces where the user is the only owner.
+func (s *MembershipStore) SoleOwnerOf(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	owned, err := s.list(ctx, bson.M{"user_id": userID, "role": WorkspaceRoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(owned))
+	for i, membership := range owned {
+		ids[i] = membership.WorkspaceID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	values, err := s.workspaces.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}, "owner_count": bson.M{"$lte": 1}})
+	if err != nil {
+		return nil, err
+	}
+
+	sole := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(primitive.ObjectID); ok {
+			sole = append(sole, id)
+		}
+	}
+
+	return sole, nil
+}
+
+func (s *MembershipStore) DeleteAllByWorkspace(ctx context.Context, workspaceID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"workspace_id": workspaceID})
+	return err
+}
+
+// DeleteAllByUser ends every membership of the user. Owner memberships go one
+// by one through Delete, so it returns ErrLastOwner rather than leave a workspace without owners.
+func (s *MembershipStore) DeleteAllByUser(ctx context.Context, userID primitive.ObjectID) error {
+	owned, err := s.list(ctx, bson.M{"user_id": userID, "role": WorkspaceRoleOwner})
+	if err != nil {
+		return err
+	}
+
+	for _, membership := range owned {
+		if err := s.Delete(ctx, membership.WorkspaceID, userID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err = s.collection.DeleteMany(ctx, bson.M{"user_id": userID, "role": bson.M{"$ne": WorkspaceRoleOwner}})
+	return err
+}
+
+// Invitation asks someone, by email, to join a workspace. Only the hash of the mailed token is stored.
+type Invitation struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID primitive.ObjectID `bson:"workspace_id" json:"workspace_id"`
+	Email       string             `bson:"email" json:"email"`
+	Role        string             `bson:"role" json:"role"`
+	InvitedBy   primitive.ObjectID `bson:"invited_by" json:"invited_by"`
+	TokenHash   string             `bson:"token_hash" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+type InvitationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *InvitationStore) Create(ctx context.Context, invitation *Invitation) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	invitation.CreatedAt = time.Now()
+
+	res, err := s.collection.InsertOne(ctx, invitation)
+	if err != nil {
+		return err
+	}
+
+	invitation.ID = res.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetByHash returns an invitation that hasn't expired.
+func (s *InvitationStore) GetByHash(ctx context.Context, hash string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var invitation Invitation
+	err := s.collection.FindOne(ctx, bson.M{
+		"token_hash": hash,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&invitation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+func (s *InvitationStore) ListByWorkspace(ctx context.Context, workspaceID primitive.ObjectID) ([]Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"workspace_id": workspaceID,
+		"expires_at":   bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invitations := []Invitation{}
+	if err := cursor.All(ctx, &invitations); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Delete removes an invitation of the workspace, it is used for revoking and accepting them.
+func (s *InvitationStore) Delete(ctx context.Context, workspaceID, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id, "workspace_id": workspaceID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *InvitationStore) DeleteAllByWorkspace(ctx context.Context, workspaceID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"workspace_id": workspaceID})
+	return err
+}
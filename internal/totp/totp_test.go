@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// Appendix B, SHA-1. The RFC lists 8 digit codes, ours are their last 6 digits.
+	for _, test := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	} {
+		code, err := Code(rfcSecret, Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.want {
+			t.Errorf("T=%d: code = %s, want %s", test.unix, code, test.want)
+		}
+	}
+}
+
+func TestCodeRejectsInvalidSecrets(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateAllowsSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for offset, want := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now, 1)
+		if ok != want {
+			t.Errorf("code %d steps away: ok = %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %d steps away: step = %d, want %d", offset, step, current+offset)
+		}
+	}
+
+	code, _ := Code(rfcSecret, current-1)
+	if _, ok := Validate(rfcSecret, code, now, 0); ok {
+		t.Error("previous code accepted without skew")
+	}
+}
+
+func TestValidateChecksLength(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	for _, code := range []string{"", "50471", "0504710", "14050471", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("%q accepted", code)
+		}
+	}
+
+	// The way apps show it, with a space in the middle
+	for _, code := range []string{"050 471", "050-471", " 050471 "} {
+		if _, ok := Validate(rfcSecret, code, now, 0); !ok {
+			t.Errorf("%q refused", code)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package useragent
+
+import "strings"
+
+const Unknown = "unknown"
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// Info is the coarse classification of a User-Agent header we keep for analytics.
+type Info struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+type rule struct {
+	token string
+	name  string
+}
+
+// Order matters, most browsers also claim to be the ones they are based on
+// (Edge and Opera send "Chrome", Chrome sends "Safari").
+var browserRules = []rule{
+	{"edg", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"firefox", "Firefox"},
+	{"fxios", "Firefox"},
+	{"crios", "Chrome"},
+	{"chromium", "Chromium"},
+	{"chrome", "Chrome"},
+	{"msie", "Internet Explorer"},
+	{"trident", "Internet Explorer"},
+	{"safari", "Safari"},
+	{"curl", "curl"},
+	{"wget", "Wget"},
+}
+
+var osRules = []rule{
+	{"windows", "Windows"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"android", "Android"},
+	{"cros", "Chrome OS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+var botTokens = []string{"bot", "crawler", "spider", "slurp", "preview", "curl", "wget", "python-requests", "go-http-client", "headless"}
+
+// Parse classifies a User-Agent header. It is deliberately simple,
+// good enough for breakdowns but not for feature detection.
+func Parse(ua string) Info {
+	if ua == "" {
+		return Info{Browser: Unknown, OS: Unknown, Device: Unknown}
+	}
+
+	s := strings.ToLower(ua)
+
+	return Info{
+		Browser: match(s, browserRules),
+		OS:      match(s, osRules),
+		Device:  device(s),
+	}
+}
+
+func match(s string, rules []rule) string {
+	for _, r := range rules {
+		if strings.Contains(s, r.token) {
+			return r.name
+		}
+	}
+	return Unknown
+}
+
+func device(s string) string {
+	for _, token := range botTokens {
+		if strings.Contains(s, token) {
+			return DeviceBot
+		}
+	}
+
+	switch {
+	case strings.Contains(s, "ipad"), strings.Contains(s, "tablet"),
+		strings.Contains(s, "android") && !strings.Contains(s, "mobile"):
+		return DeviceTablet
+	case strings.Contains(s, "mobi"), strings.Contains(s, "iphone"), strings.Contains(s, "ipod"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}